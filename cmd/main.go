@@ -7,7 +7,13 @@ import (
 	"blog/internal/repository"
 	"blog/internal/services"
 	"blog/middlewares"
+	"blog/oidc"
+	"blog/storage"
+	"blog/utils"
+	"context"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
@@ -27,7 +33,31 @@ func main() {
 		log.Fatalf("Bad connection to PostgreSQL: %v", err)
 	}
 
-	if err := database.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{}); err != nil {
+	// Handles that clash ignoring case or are reserved must be renamed before their unique index is built.
+	renamed, err := repository.RenameConflictingUsernames(database, services.ReservedUsernames())
+	if err != nil {
+		log.Fatalf("Bad migration: %v", err)
+	}
+	if renamed > 0 {
+		log.Printf("Renamed %d users with conflicting or reserved usernames", renamed)
+	}
+
+	if err := database.AutoMigrate(&models.User{}, &models.UsernameHistory{}, &models.UserIdentity{}, &models.RecoveryCode{}, &models.AccessToken{}, &models.Post{}, &models.PostRevision{}, &models.Comment{},
+		&models.ModerationAction{}, &models.SecurityEvent{}); err != nil {
+		log.Fatalf("Bad migration: %v", err)
+	}
+
+	postRepo := repository.NewPostRepository(database)
+	backfilled, err := postRepo.BackfillPublishedAt()
+	if err != nil {
+		log.Fatalf("Bad migration: %v", err)
+	}
+	if backfilled > 0 {
+		log.Printf("Recorded the publication time of %d posts", backfilled)
+	}
+
+	securityEventRepo := repository.NewSecurityEventRepository(database)
+	if err := securityEventRepo.MakeAppendOnly(); err != nil {
 		log.Fatalf("Bad migration: %v", err)
 	}
 
@@ -43,42 +73,198 @@ func main() {
 		log.Fatalf("Bad connection to Redis: %v", err)
 	}
 
+	// Connecting to Redis
+	redisLogin, err := db.ConnectToRedis(2)
+	if err != nil {
+		log.Fatalf("Bad connection to Redis: %v", err)
+	}
+
+	// Storage for uploaded files such as avatars
+	fileStorage, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Bad storage configuration: %v", err)
+	}
+
+	// Hasher for new passwords, existing hashes of other algorithms are upgraded on login
+	passwordHasher, err := utils.NewPasswordHasherFromEnv()
+	if err != nil {
+		log.Fatalf("Bad password hasher configuration: %v", err)
+	}
+
+	// Login with an external OpenID Connect provider, enabled by OIDC_ISSUER
+	oidcConfig, err := oidc.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Bad OIDC configuration: %v", err)
+	}
+
+	// Email verification is required unless explicitly disabled, e.g. in dev environments.
+	requireVerifiedEmail := os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+
 	s := chi.NewRouter()
 
 	//Router for working with the user (registration, email confirmation, login)
 	userRepo := repository.NewUserRepository(database, redisSession, redisCode)
-	userService := services.NewUserService(userRepo)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(redisLogin)
+	userService := services.NewUserService(userRepo, loginAttemptRepo, securityEventRepo, passwordHasher, fileStorage, requireVerifiedEmail)
 	userHandler := handlers.NewUserHandler(userService)
+
+	// Promoting the users listed in ADMIN_EMAILS (comma-separated) to admins, which creates the first admin
+	if err := userService.PromoteAdmins(adminEmails(os.Getenv("ADMIN_EMAILS"))); err != nil {
+		log.Fatalf("Bad admin promotion: %v", err)
+	}
+
+	avatarService := services.NewAvatarService(userRepo, fileStorage)
+	avatarHandler := handlers.NewAvatarHandler(avatarService)
+	accessTokenRepo := repository.NewAccessTokenRepository(database)
+	accessTokenService := services.NewAccessTokenService(accessTokenRepo)
+	accessTokenHandler := handlers.NewAccessTokenHandler(accessTokenService)
 	s.Post("/users", userHandler.RegisterUser)
 	s.Post("/verify", userHandler.VerifyEmail)
+	s.Post("/verify/resend", userHandler.ResendVerificationCode)
 	s.Post("/login", userHandler.LoginUser)
+	s.Post("/login/2fa", userHandler.LoginTwoFactor)
+	s.Post("/login/magic", userHandler.RequestMagicLink)
+	s.Get("/login/magic/{token}", userHandler.LoginWithMagicLink)
+	if oidcConfig != nil {
+		oidcService := services.NewOIDCService(userService, oidc.NewProvider(*oidcConfig))
+		oidcHandler := handlers.NewOIDCHandler(userHandler, oidcService)
+		s.Get("/login/oidc", oidcHandler.StartLogin)
+		s.Get("/login/oidc/callback", oidcHandler.Callback)
+	}
+	s.Post("/password/forgot", userHandler.ForgotPassword)
+	s.Post("/password/reset", userHandler.ResetPassword)
+	s.Get("/users/{userID}", userHandler.GetProfile)
+	s.Get("/users/by-username/{username}", userHandler.GetProfileByUsername)
+
+	//Files of the local storage (avatars) are served by the application itself.
+	if localStorage, ok := fileStorage.(*storage.LocalStorage); ok {
+		s.Handle("/media/*", http.StripPrefix("/media/", http.FileServer(localStorage.FileSystem())))
+	}
+
+	//Grouping routes for managing the user's own account, sessions and access tokens.
+	//They are only available with a session cookie, not with a personal access token.
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.CSRFProtect)
+		s.Use(middlewares.RequireSessionCookie)
+		s.Get("/csrf", userHandler.GetCSRFToken)
+		s.Post("/logout", userHandler.Logout)
+		s.Post("/sessions/revoke-all", userHandler.RevokeAllSessions)
+		s.Get("/sessions", userHandler.ListSessions)
+		s.Delete("/sessions/{id}", userHandler.DeleteSession)
+		s.Get("/users/me", userHandler.GetOwnProfile)
+		s.Patch("/users/me", userHandler.UpdateProfile)
+		s.Delete("/users/me", userHandler.DeleteAccount)
+		s.Get("/users/me/export", userHandler.ExportAccount)
+		s.Get("/users/me/security-events", userHandler.GetSecurityEvents)
+		s.Put("/users/me/username", userHandler.ChangeUsername)
+		s.Put("/users/me/avatar", avatarHandler.UploadAvatar)
+		s.Delete("/users/me/avatar", avatarHandler.DeleteAvatar)
+		s.Put("/users/me/password", userHandler.ChangePassword)
+		s.Put("/users/me/email", userHandler.ChangeEmail)
+		s.Post("/users/me/email/verify", userHandler.VerifyEmailChange)
+		s.Post("/users/me/2fa", userHandler.EnableTwoFactor)
+		s.Post("/users/me/2fa/confirm", userHandler.ConfirmTwoFactor)
+		s.Delete("/users/me/2fa", userHandler.DisableTwoFactor)
+		s.Post("/users/me/tokens", accessTokenHandler.CreateToken)
+		s.Get("/users/me/tokens", accessTokenHandler.GetTokens)
+		s.Delete("/users/me/tokens/{tokenID}", accessTokenHandler.DeleteToken)
+	})
 
 	//Router for working with posts (creating, receiving and deleting)
-	postRepo := repository.NewPostRepository(database)
-	postService := services.NewPostService(postRepo)
+	postService := services.NewPostService(postRepo, userRepo)
 	postHandler := handlers.NewPostHandlers(postService)
 
-	//Grouping routes for posts using middleware to check sessions.
+	//Grouping routes for posts using middleware to check sessions or access tokens with the posts:write scope.
 	s.Group(func(s chi.Router) {
-		s.Use(middlewares.SessionMiddleware(userRepo))
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.CSRFProtect)
+		s.Use(middlewares.RequireScope(models.ScopePostsWrite))
+		if requireVerifiedEmail {
+			s.Use(middlewares.RequireVerifiedEmail(userRepo))
+		}
 		s.Post("/posts", postHandler.NewPost)
+		s.Patch("/posts/{postID}", postHandler.UpdatePost)
 		s.Delete("/posts/{postID}", postHandler.DeletePost)
+		s.Post("/posts/{postID}/revisions/{rev}/restore", postHandler.RestoreRevision)
+	})
+	//Revisions are part of editing posts, so access tokens need the posts:write scope to read them as well.
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.RequireScope(models.ScopePostsWrite))
+		s.Get("/posts/{postID}/revisions", postHandler.GetRevisions)
+		s.Get("/posts/{postID}/revisions/diff", postHandler.DiffRevisions)
+	})
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.OptionalSession(userRepo, accessTokenRepo))
+		s.Get("/posts/{postID}", postHandler.GetPost)
+		s.Get("/users/{handle}/posts", postHandler.GetUserPosts)
 	})
-	s.Get("/posts/{userID}", postHandler.GetPosts)
+	s.Get("/feed", postHandler.GetFeed)
+
+	// Publishing scheduled posts in the background
+	go postService.RunScheduler(context.Background())
 
 	//Router for working with comments (creating, receiving and deleting)
 	commentRepo := repository.NewCommentRepository(database)
-	commentService := services.NewCommentService(commentRepo)
+	commentService := services.NewCommentService(commentRepo, postRepo)
 	commentHandler := handlers.NewCommentHandler(commentService)
 
-	//Grouping routes for comments using middleware to check sessions.
+	//Grouping routes for comments using middleware to check sessions or access tokens with the comments:write scope.
 	s.Group(func(s chi.Router) {
-		s.Use(middlewares.SessionMiddleware(userRepo))
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.CSRFProtect)
+		s.Use(middlewares.RequireScope(models.ScopeCommentsWrite))
+		if requireVerifiedEmail {
+			s.Use(middlewares.RequireVerifiedEmail(userRepo))
+		}
 		s.Post("/posts/{postID}/comment", commentHandler.NewComment)
 		s.Delete("/posts/{postID}/comment/{commentID}", commentHandler.DeleteComment)
 	})
-	s.Get("/posts/{postID}/comment", commentHandler.GetComments)
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.OptionalSession(userRepo, accessTokenRepo))
+		s.Get("/posts/{postID}/comment", commentHandler.GetComments)
+	})
+
+	//Router for moderators (hiding and deleting any post or comment) and admins (changing roles)
+	moderationRepo := repository.NewModerationRepository(database)
+	moderationService := services.NewModerationService(moderationRepo)
+	moderationHandler := handlers.NewModerationHandler(moderationService)
+
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.CSRFProtect)
+		s.Use(middlewares.RequireSessionCookie)
+		s.Use(middlewares.RequireRole(userRepo, models.RoleModerator, models.RoleAdmin))
+		s.Post("/moderation/posts/{postID}/hide", moderationHandler.HidePost)
+		s.Post("/moderation/posts/{postID}/unhide", moderationHandler.UnhidePost)
+		s.Delete("/moderation/posts/{postID}", moderationHandler.DeletePost)
+		s.Post("/moderation/comments/{commentID}/hide", moderationHandler.HideComment)
+		s.Post("/moderation/comments/{commentID}/unhide", moderationHandler.UnhideComment)
+		s.Delete("/moderation/comments/{commentID}", moderationHandler.DeleteComment)
+		s.Get("/moderation/actions", moderationHandler.GetActions)
+	})
+
+	s.Group(func(s chi.Router) {
+		s.Use(middlewares.SessionMiddleware(userRepo, accessTokenRepo))
+		s.Use(middlewares.CSRFProtect)
+		s.Use(middlewares.RequireSessionCookie)
+		s.Use(middlewares.RequireRole(userRepo, models.RoleAdmin))
+		s.Put("/admin/users/{userID}/role", userHandler.SetRole)
+		s.Get("/admin/security-events", userHandler.QuerySecurityEvents)
+	})
 
 	http.ListenAndServe(":8080", s)
 
 }
+
+// adminEmails splits the comma-separated ADMIN_EMAILS value into addresses, skipping empty entries.
+func adminEmails(value string) []string {
+	var emails []string
+	for _, email := range strings.Split(value, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
@@ -16,7 +16,8 @@ func Connect() (*gorm.DB, error) {
 		os.Getenv("DB_HOST_POSTGRES"), os.Getenv("DB_PORT_POSTGRES"), os.Getenv("DB_USER_POSTGRES"), os.Getenv("DB_PASSWORD_POSTGRES"),
 		os.Getenv("DB_NAME_POSTGRES"), os.Getenv("DB_SSLMODE_POSTGRES"))
 
-	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{})
+	// TranslateError turns driver errors such as unique violations into gorm errors like gorm.ErrDuplicatedKey.
+	db, err := gorm.Open(postgres.Open(conn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
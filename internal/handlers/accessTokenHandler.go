@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"blog/internal/models"
+	"blog/internal/services"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type AccessTokenHandler struct {
+	AccessTokenService *services.AccessTokenService
+}
+
+func NewAccessTokenHandler(accessTokenService *services.AccessTokenService) *AccessTokenHandler {
+	return &AccessTokenHandler{AccessTokenService: accessTokenService}
+}
+
+// CreateToken - handles the creation of a personal access token for the current user.
+// It decodes the token name, scopes and lifetime from the JSON request and calls the service to create the token.
+// On success, it returns status 201 (Created) with the token record and the plain token, which is shown only once.
+func (a *AccessTokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+
+	type CreateTokenRequest struct {
+		Name          string
+		Scopes        []string
+		ExpiresInDays int `json:"expires_in_days"`
+	}
+
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	plain, token, err := a.AccessTokenService.CreateToken(userID, req.Name, req.Scopes, req.ExpiresInDays)
+	if err != nil {
+		if errors.Is(err, services.ErrTokenNameEmpty) || errors.Is(err, services.ErrUnknownScope) || errors.Is(err, services.ErrInvalidToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		*models.AccessToken
+		Token string `json:"token"`
+	}{AccessToken: token, Token: plain}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode token: %v", err)
+	}
+}
+
+// GetTokens - handles the request to list the personal access tokens of the current user.
+// The tokens themselves are never returned, only their names, scopes and dates.
+// The list is paginated with the "cursor", "limit" and "sort" (newest or oldest) query parameters.
+func (a *AccessTokenHandler) GetTokens(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	page, err := parsePage(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := a.AccessTokenService.GetTokens(userID, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setNextLink(w, r, tokens.NextCursor)
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		log.Printf("Failed to encode tokens: %v", err)
+		http.Error(w, "Failed to encode tokens", http.StatusInternalServerError)
+	}
+}
+
+// DeleteToken - handles the request to revoke a personal access token of the current user.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if the user has no such token.
+func (a *AccessTokenHandler) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	tokenIDstr := chi.URLParam(r, "tokenID")
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := a.AccessTokenService.DeleteToken(tokenIDstr, userID); err != nil {
+		if errors.Is(err, services.ErrTokenNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
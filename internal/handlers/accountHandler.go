@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// This handler returns a ZIP archive with all data stored about the current user.
+// The archive contains profile.json, posts.json, comments.json and sessions.json.
+func (u *UserHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	export, err := u.UserService.ExportAccount(userID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"posts.json", export.Posts},
+		{"comments.json", export.Comments},
+		{"sessions.json", export.Sessions},
+	}
+
+	// The archive is built in memory first, so an error can still be reported with a proper status.
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		fw, err := archive.Create(file.name)
+		if err == nil {
+			encoder := json.NewEncoder(fw)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.data)
+		}
+		if err != nil {
+			log.Printf("Failed to write %s of export: %v", file.name, err)
+			http.Error(w, "Failed to create export", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish export archive: %v", err)
+		http.Error(w, "Failed to create export", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("blog-export-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Failed to send export: %v", err)
+	}
+}
+
+// This handler permanently deletes the account of the current user. The password must be confirmed.
+// The session cookie is cleared. On success, it returns status 204 (No Content).
+func (u *UserHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type DeleteAccountRequest struct {
+		Password string `json:"password"`
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.DeleteAccount(userID, req.Password); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
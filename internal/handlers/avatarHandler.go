@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"blog/internal/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+type AvatarHandler struct {
+	AvatarService *services.AvatarService
+}
+
+func NewAvatarHandler(avatarService *services.AvatarService) *AvatarHandler {
+	return &AvatarHandler{AvatarService: avatarService}
+}
+
+// UploadAvatar - handles a multipart upload of the current user's avatar in the "avatar" form field.
+// Files larger than the limit are rejected with 413 (Request Entity Too Large), unsupported images with 415.
+// On success, it returns status 200 (OK) with the URLs of the new avatar.
+func (a *AvatarHandler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	// Leave some room for the multipart headers on top of the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxAvatarUploadSize+64<<10)
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Avatar file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Invalid avatar upload: %v", err)
+		http.Error(w, "Expected a multipart form with an \"avatar\" file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxAvatarUploadSize+1))
+	if err != nil {
+		log.Printf("Failed to read avatar upload: %v", err)
+		http.Error(w, "Failed to read avatar", http.StatusBadRequest)
+		return
+	}
+	if len(data) > services.MaxAvatarUploadSize {
+		http.Error(w, "Avatar file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	user, err := a.AvatarService.UpdateAvatar(userID, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnsupportedImage):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.Is(err, services.ErrImageTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := map[string]string{
+		"avatar_url":       user.AvatarURL,
+		"avatar_thumb_url": user.AvatarThumbURL,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode avatar: %v", err)
+		http.Error(w, "Failed to encode avatar", http.StatusInternalServerError)
+	}
+}
+
+// DeleteAvatar - handles removing the current user's avatar.
+// On success, it returns status 204 (No Content).
+func (a *AvatarHandler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := a.AvatarService.DeleteAvatar(userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,6 +4,7 @@ import (
 	"blog/internal/models"
 	"blog/internal/services"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -21,10 +22,15 @@ func NewCommentHandler(commentService *services.CommentServices) *CommentHandler
 
 // NewComment - handles the creation of a new comment for the specified post.
 // It decodes the JSON request, extracts postID and userID, then calls the service method to create the comment.
-// In case of errors (invalid JSON, service error), it returns the appropriate status codes.
+// In case of errors (invalid JSON, service error), it returns the appropriate status codes,
+// and 404 if the post does not exist or is not visible to the user.
 func (c *CommentHandler) NewComment(w http.ResponseWriter, r *http.Request) {
-	var comment models.Comment
-	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+	type NewCommentRequest struct {
+		Content string `json:"content"`
+	}
+
+	var req NewCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid JSON received: %v", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
@@ -34,26 +40,36 @@ func (c *CommentHandler) NewComment(w http.ResponseWriter, r *http.Request) {
 	postIDstr := chi.URLParam(r, "postID")
 
 	userID := r.Context().Value("userID").(uuid.UUID)
+	comment := models.Comment{Content: req.Content}
 	if err := c.CommentService.CreateComment(&comment, userID, postIDstr); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeCommentError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GetComments - handles fetching all comments for the specified post.
+// GetComments - handles fetching a page of the comments for the specified post.
 // It retrieves the postID from the URL parameters and calls the service method to get the comments.
-// In case of an error, it returns an HTTP error if comments cannot be fetched.
+// It accepts the "cursor", "limit" and "sort" (newest or oldest, oldest by default) query parameters.
+// It returns 404 if the post does not exist or is not visible to the viewer, who may be anonymous,
+// and an HTTP error if comments cannot be fetched.
 func (c *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
 	postIdstr := chi.URLParam(r, "postID")
 
-	comments, err := c.CommentService.GetComments(postIdstr)
+	page, err := parsePage(r.URL.Query(), true)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	comments, err := c.CommentService.GetComments(postIdstr, viewerID(r), page)
+	if err != nil {
+		writeCommentError(w, err)
 		return
 	}
 
+	setNextLink(w, r, comments.NextCursor)
 	if err := json.NewEncoder(w).Encode(comments); err != nil {
 		log.Printf("Failed to encode comments: %v", err)
 		http.Error(w, "Failed to encode comments", http.StatusInternalServerError)
@@ -76,3 +92,12 @@ func (c *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// writeCommentError writes the HTTP error matching a comment service error.
+func writeCommentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrPostNotFound) || errors.Is(err, services.ErrCommentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
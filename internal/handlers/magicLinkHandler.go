@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"blog/internal/services"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// This handler emails a single-use login link to the given address.
+// It always returns status 202 (Accepted), so it cannot be used to find out which emails are registered.
+func (u *UserHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+
+	type MagicLinkRequest struct {
+		Email string
+	}
+
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.RequestMagicLink(req.Email); err != nil {
+		if errors.Is(err, services.ErrMagicLinkDisabled) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to send login link: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// This handler logs the user in with the token from a login link.
+// On success, it sets the same session cookie as a password login and returns the user's profile,
+// or a challenge token if the user has two-factor authentication enabled.
+func (u *UserHandler) LoginWithMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	meta := clientMeta(r)
+	result, err := u.UserService.LoginWithMagicLink(token, meta)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	u.writeLoginResult(w, result)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"blog/internal/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type ModerationHandler struct {
+	ModerationService *services.ModerationService
+}
+
+func NewModerationHandler(moderationService *services.ModerationService) *ModerationHandler {
+	return &ModerationHandler{ModerationService: moderationService}
+}
+
+// moderationRequest is the optional body of moderation requests.
+type moderationRequest struct {
+	Reason string
+}
+
+// HidePost - handles hiding any post by a moderator. The reason is taken from the optional JSON body.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such post.
+func (m *ModerationHandler) HidePost(w http.ResponseWriter, r *http.Request) {
+	m.setPostHidden(w, r, true)
+}
+
+// UnhidePost - handles making a hidden post visible again.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such post.
+func (m *ModerationHandler) UnhidePost(w http.ResponseWriter, r *http.Request) {
+	m.setPostHidden(w, r, false)
+}
+
+func (m *ModerationHandler) setPostHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	req, ok := decodeModerationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	postIDstr := chi.URLParam(r, "postID")
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := m.ModerationService.SetPostHidden(postIDstr, moderatorID, hidden, req.Reason); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeletePost - handles deleting any post with its comments by a moderator.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such post.
+func (m *ModerationHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	postIDstr := chi.URLParam(r, "postID")
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := m.ModerationService.DeletePost(postIDstr, moderatorID, req.Reason); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HideComment - handles hiding any comment by a moderator.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such comment.
+func (m *ModerationHandler) HideComment(w http.ResponseWriter, r *http.Request) {
+	m.setCommentHidden(w, r, true)
+}
+
+// UnhideComment - handles making a hidden comment visible again.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such comment.
+func (m *ModerationHandler) UnhideComment(w http.ResponseWriter, r *http.Request) {
+	m.setCommentHidden(w, r, false)
+}
+
+func (m *ModerationHandler) setCommentHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	req, ok := decodeModerationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	commentIDstr := chi.URLParam(r, "commentID")
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := m.ModerationService.SetCommentHidden(commentIDstr, moderatorID, hidden, req.Reason); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteComment - handles deleting any comment by a moderator.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such comment.
+func (m *ModerationHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	commentIDstr := chi.URLParam(r, "commentID")
+	moderatorID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := m.ModerationService.DeleteComment(commentIDstr, moderatorID, req.Reason); err != nil {
+		writeModerationError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetActions - handles the request to list a page of the moderation actions, newest first.
+// It accepts the "cursor", "limit" and "sort" (newest or oldest) query parameters.
+func (m *ModerationHandler) GetActions(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	actions, err := m.ModerationService.GetActions(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setNextLink(w, r, actions.NextCursor)
+	if err := json.NewEncoder(w).Encode(actions); err != nil {
+		log.Printf("Failed to encode moderation actions: %v", err)
+		http.Error(w, "Failed to encode moderation actions", http.StatusInternalServerError)
+	}
+}
+
+// decodeModerationRequest reads the optional JSON body with the reason of the action.
+func decodeModerationRequest(w http.ResponseWriter, r *http.Request) (moderationRequest, bool) {
+	var req moderationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return req, false
+	}
+	defer r.Body.Close()
+	return req, true
+}
+
+// writeModerationError maps errors of the moderation service to HTTP status codes.
+func writeModerationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrPostNotFound) || errors.Is(err, services.ErrCommentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"blog/internal/repository"
+	"blog/internal/services"
+	"log"
+	"net/http"
+)
+
+// oidcStateCookie holds the browser binding of a login started with the external provider.
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler serves the login with an external OpenID Connect provider.
+// It embeds the UserHandler to answer like a password login once the user is signed in.
+type OIDCHandler struct {
+	*UserHandler
+	OIDCService *services.OIDCService
+}
+
+func NewOIDCHandler(userHandler *UserHandler, oidcService *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{UserHandler: userHandler, OIDCService: oidcService}
+}
+
+// StartLogin - redirects the user to the login page of the external provider with status 302 (Found).
+// It sets a short-lived cookie that ties the login to this browser.
+func (o *OIDCHandler) StartLogin(w http.ResponseWriter, r *http.Request) {
+
+	authURL, binding, err := o.OIDCService.StartLogin()
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	cookie := oidcBindingCookie(binding)
+	cookie.MaxAge = int(repository.OIDCStateTTL.Seconds())
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback - handles the redirect back from the external provider.
+// On success, it sets the session cookie and returns the user's profile like POST /login,
+// or a challenge token if the user has two-factor authentication enabled.
+// Callbacks in a browser without the cookie set by StartLogin are rejected with 400 (Bad Request).
+func (o *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var binding string
+	if cookie, err := r.Cookie(oidcStateCookie); err == nil {
+		binding = cookie.Value
+	}
+	// The binding is used once, whatever the outcome.
+	cleared := oidcBindingCookie("")
+	cleared.MaxAge = -1
+	http.SetCookie(w, cleared)
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("OIDC provider returned error %s: %s", providerErr, query.Get("error_description"))
+		http.Error(w, "Login was cancelled or rejected by the provider", http.StatusUnauthorized)
+		return
+	}
+
+	meta := clientMeta(r)
+	result, err := o.OIDCService.CompleteLogin(query.Get("state"), binding, query.Get("code"), meta)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	o.writeLoginResult(w, result)
+}
+
+// oidcBindingCookie returns the cookie with the browser binding of an external login.
+// It is sent only to the login routes, and SameSite=Lax lets it through on the provider's redirect back.
+func oidcBindingCookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
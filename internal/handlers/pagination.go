@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"blog/internal/repository"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Values of the "sort" query parameter of paginated lists.
+const (
+	sortNewest = "newest"
+	sortOldest = "oldest"
+)
+
+// parsePage reads the "cursor", "limit" and "sort" query parameters of a paginated list.
+// The sort is "newest" or "oldest"; without it, the list is sorted oldest first if ascending is set.
+func parsePage(query url.Values, ascending bool) (repository.Page, error) {
+	page := repository.Page{Ascending: ascending}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return page, errors.New("invalid limit")
+		}
+		page.Limit = limit
+	}
+
+	switch query.Get("sort") {
+	case "":
+	case sortNewest:
+		page.Ascending = false
+	case sortOldest:
+		page.Ascending = true
+	default:
+		return page, errors.New("invalid sort, expected " + sortNewest + " or " + sortOldest)
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := repository.ParseCursor(v)
+		if err != nil {
+			return page, err
+		}
+		page.After = cursor
+	}
+
+	return page, nil
+}
+
+// setNextLink sets the Link header to the URL of the next page of the list, if there is one.
+func setNextLink(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	next.RawQuery = query.Encode()
+	w.Header().Add("Link", "<"+next.String()+">; rel=\"next\"")
+}
@@ -3,14 +3,27 @@ package handlers
 import (
 	"blog/internal/models"
 	"blog/internal/services"
+	"blog/utils"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// The old GET /posts/{userID} listing was deprecated when GET /users/{userID}/posts replaced it,
+// and is removed at postsRouteSunset. Both are announced in the Deprecation (RFC 9745) and Sunset (RFC 8594) headers.
+var (
+	postsRouteDeprecated = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	postsRouteSunset     = time.Date(2027, 4, 18, 0, 0, 0, 0, time.UTC)
+)
+
 type PostHandler struct {
 	PostServices *services.PostService
 }
@@ -22,46 +35,169 @@ func NewPostHandlers(postService *services.PostService) *PostHandler {
 }
 
 // NewPost - handles the creation of a new post. It decodes the JSON request, extracts the userID from the context, and tries to create a new post through the service.
+// The optional status is one of draft, scheduled (with publish_at), published, unlisted or private, and defaults to published.
 // In case of errors during decoding or creating the post, the corresponding error status is returned.
 // If the post creation is successful, it returns status 201 (Created).
 func (p *PostHandler) NewPost(w http.ResponseWriter, r *http.Request) {
-	var post models.Post
-	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
-		log.Printf("Invalid JSON received: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	type NewPostRequest struct {
+		Title     string     `json:"title"`
+		Content   string     `json:"content"`
+		Status    string     `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	var req NewPostRequest
+	if !decodePostRequest(w, r, &req) {
+		return
 	}
-	defer r.Body.Close()
 
 	userID := r.Context().Value("userID").(uuid.UUID)
+	post := models.Post{Title: req.Title, Content: req.Content, Status: req.Status, PublishAt: req.PublishAt}
 
 	err := p.PostServices.NewPost(&post, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePostError(w, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GetPosts - handles the request to fetch all posts for the specified user. It extracts the userID from the URL parameters and calls the service to get the posts.
-// In case of an error, it returns status 500 (Internal Server Error).
-// If posts are successfully retrieved, they are encoded to JSON and sent to the client with status 200 (OK).
-func (p *PostHandler) GetPosts(w http.ResponseWriter, r *http.Request) {
-	userIDstr := chi.URLParam(r, "userID")
+// GetPost - handles the request to fetch a single post with a summary of its author and its comment count.
+// If there is no such post, or it is a draft, private or scheduled post of another user, it returns status 404 (Not Found).
+// For compatibility, a user ID in place of the post ID returns all posts of that user, as this route did before;
+// such responses carry Deprecation and Sunset headers and link to GET /users/{userID}/posts instead.
+func (p *PostHandler) GetPost(w http.ResponseWriter, r *http.Request) {
+	postIDStr := chi.URLParam(r, "postID")
+
+	if _, err := uuid.Parse(postIDStr); err == nil {
+		p.getPostsDeprecated(w, r, postIDStr)
+		return
+	}
 
-	posts, err := p.PostServices.GetPosts(userIDstr)
+	post, err := p.PostServices.GetPost(postIDStr, viewerID(r))
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Failed to encode post: %v", err)
+		http.Error(w, "Failed to encode post", http.StatusInternalServerError)
+	}
+}
+
+// getPostsDeprecated serves the old GET /posts/{userID} listing of the posts of the user.
+// Unlike GET /users/{userID}/posts it is not paginated and returns all posts as a plain array, as it always did.
+func (p *PostHandler) getPostsDeprecated(w http.ResponseWriter, r *http.Request, userIDstr string) {
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(postsRouteDeprecated.Unix(), 10))
+	w.Header().Set("Sunset", postsRouteSunset.Format(http.TimeFormat))
+	w.Header().Set("Link", "</users/"+userIDstr+"/posts>; rel=\"successor-version\"")
+
+	posts, err := p.PostServices.GetAllPosts(userIDstr, viewerID(r))
 	if err != nil {
 		http.Error(w, "Error while get posts", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(posts); err != nil {
+		log.Printf("Failed to encode posts: %v", err)
+		http.Error(w, "Failed to encode posts", http.StatusInternalServerError)
+	}
+}
+
+// GetUserPosts - handles the request to fetch a page of the posts of the user with the handle or user ID from the URL.
+// Other users only get the published posts, the user themselves gets all of their posts.
+// It accepts the "cursor", "limit" and "sort" (newest or oldest, newest by default) query parameters.
+// If the user has changed their handle, it redirects (301) to the posts under the new handle.
+// If there is no such user, it returns status 404 (Not Found).
+func (p *PostHandler) GetUserPosts(w http.ResponseWriter, r *http.Request) {
+	handle := chi.URLParam(r, "handle")
+
+	page, err := parsePage(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	posts, err := p.PostServices.GetPostsByUsername(handle, viewerID(r), page)
+	if err != nil {
+		var movedErr *services.UsernameMovedError
+		switch {
+		case errors.As(err, &movedErr):
+			target := url.URL{Path: "/users/" + movedErr.Username + "/posts", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		case errors.Is(err, services.ErrUserNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Error while get posts", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setNextLink(w, r, posts.NextCursor)
 	if err := json.NewEncoder(w).Encode(posts); err != nil {
 		log.Printf("Failed to encode posts: %v", err)
 		http.Error(w, "Failed to encode posts", http.StatusInternalServerError)
 	}
-	w.WriteHeader(http.StatusOK)
+}
+
+// GetFeed - handles the request to fetch a page of the published posts of all users, with their authors and comment counts.
+// It accepts the "cursor", "limit" and "sort" (newest or oldest, newest by default) query parameters.
+// The response contains the cursor of the next page, which is also linked in the Link header.
+func (p *PostHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	feed, err := p.PostServices.GetFeed(page)
+	if err != nil {
+		http.Error(w, "Error while get feed", http.StatusInternalServerError)
+		return
+	}
+
+	setNextLink(w, r, feed.NextCursor)
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Failed to encode feed: %v", err)
+		http.Error(w, "Failed to encode feed", http.StatusInternalServerError)
+	}
+}
+
+// UpdatePost - handles editing the title, content, status and publish_at of a post by its author or a moderator. Omitted fields are left unchanged.
+// It returns status 404 (Not Found) if there is no such post or another user may not see it, 403 (Forbidden) if the post belongs to another user,
+// or 400 (Bad Request) if the title is empty or the status or publish_at is invalid. On success, status 200 (OK) is returned along with the updated post.
+func (p *PostHandler) UpdatePost(w http.ResponseWriter, r *http.Request) {
+	postIDStr := chi.URLParam(r, "postID")
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type UpdatePostRequest struct {
+		Title     *string    `json:"title"`
+		Content   *string    `json:"content"`
+		Status    *string    `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	var req UpdatePostRequest
+	if !decodePostRequest(w, r, &req) {
+		return
+	}
+
+	post, err := p.PostServices.UpdatePost(postIDStr, userID, req.Title, req.Content, req.Status, req.PublishAt)
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Failed to encode post: %v", err)
+		http.Error(w, "Failed to encode post", http.StatusInternalServerError)
+	}
 }
 
 // DeletePost - handles the request to delete a post for the specified user. It extracts the postID from the URL parameters and the userID from the context.
-// If the post cannot be deleted, an error with the appropriate status is returned.
+// It returns status 404 (Not Found) if there is no such post or another user may not see it, or 403 (Forbidden) if the post belongs to another user.
 // If the post is successfully deleted, status 204 (No Content).
 func (p *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	postIDStr := chi.URLParam(r, "postID")
@@ -69,9 +205,109 @@ func (p *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 
 	err := p.PostServices.DeletePost(postIDStr, userID)
 	if err != nil {
-		http.Error(w, "Error while delete post", http.StatusInternalServerError)
+		writePostError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// GetRevisions - handles the request to list all revisions of a post, newest first. Only the author and moderators can see them.
+// It returns status 404 (Not Found) if there is no such post or another user may not see it, or 403 (Forbidden) if the post belongs to another user.
+func (p *PostHandler) GetRevisions(w http.ResponseWriter, r *http.Request) {
+	postIDStr := chi.URLParam(r, "postID")
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	revisions, err := p.PostServices.GetRevisions(postIDStr, userID)
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		log.Printf("Failed to encode revisions: %v", err)
+		http.Error(w, "Failed to encode revisions", http.StatusInternalServerError)
+	}
+}
+
+// DiffRevisions - handles the request to compare the revisions given by the "from" and "to" query parameters.
+// Without "to" the latest revision is used, without "from" the revision before "to".
+// The differences are returned as a unified diff in plain text, which is empty if the revisions are equal.
+// Revisions that are too large to compare are rejected with 422 (Unprocessable Entity).
+func (p *PostHandler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	postIDStr := chi.URLParam(r, "postID")
+	userID := r.Context().Value("userID").(uuid.UUID)
+	query := r.URL.Query()
+
+	diff, err := p.PostServices.DiffRevisions(postIDStr, userID, query.Get("from"), query.Get("to"))
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	if _, err := io.WriteString(w, diff); err != nil {
+		log.Printf("Failed to write diff: %v", err)
+	}
+}
+
+// RestoreRevision - handles restoring the title and content of a post from one of its revisions.
+// The restored version becomes a new revision. On success, status 200 (OK) is returned along with the updated post.
+func (p *PostHandler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	postIDStr := chi.URLParam(r, "postID")
+	revisionStr := chi.URLParam(r, "rev")
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	post, err := p.PostServices.RestoreRevision(postIDStr, revisionStr, userID)
+	if err != nil {
+		writePostError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(post); err != nil {
+		log.Printf("Failed to encode post: %v", err)
+		http.Error(w, "Failed to encode post", http.StatusInternalServerError)
+	}
+}
+
+// decodePostRequest decodes the JSON body of a request that creates or edits a post.
+// Bodies larger than a post can be are rejected with 413 (Request Entity Too Large).
+func decodePostRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	// Leave some room for the title and the other fields on top of the content.
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxPostContentSize+64<<10)
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Post is too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// viewerID returns the ID of the logged in user, or uuid.Nil for anonymous requests on routes with OptionalSession.
+func viewerID(r *http.Request) uuid.UUID {
+	userID, _ := r.Context().Value("userID").(uuid.UUID)
+	return userID
+}
+
+// writePostError maps errors of the post service to HTTP status codes.
+func writePostError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrPostNotFound), errors.Is(err, services.ErrRevisionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrPostForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidPost), errors.Is(err, services.ErrInvalidRevision):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, utils.ErrDiffTooLarge):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"blog/internal/services"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// This handler returns the public profile of the user with the ID from the URL.
+// On success, status 200 (OK) is returned, or 404 (Not Found) if there is no such user.
+func (u *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userIDstr := chi.URLParam(r, "userID")
+
+	profile, err := u.UserService.GetPublicProfile(userIDstr)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode profile: %v", err)
+		http.Error(w, "Failed to encode profile", http.StatusInternalServerError)
+	}
+}
+
+// This handler returns the public profile of the user with the username from the URL.
+// On success, status 200 (OK) is returned, or 404 (Not Found) if there is no such user.
+// If the user has changed their handle, it redirects (301) to the profile under the new handle.
+func (u *UserHandler) GetProfileByUsername(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	profile, err := u.UserService.GetPublicProfileByUsername(username)
+	if err != nil {
+		var movedErr *services.UsernameMovedError
+		if errors.As(err, &movedErr) {
+			http.Redirect(w, r, "/users/by-username/"+url.PathEscape(movedErr.Username), http.StatusMovedPermanently)
+			return
+		}
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode profile: %v", err)
+		http.Error(w, "Failed to encode profile", http.StatusInternalServerError)
+	}
+}
+
+// This handler returns the profile of the current user, including the owner-only fields such as the email.
+func (u *UserHandler) GetOwnProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	profile, err := u.UserService.GetOwnProfile(userID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode profile: %v", err)
+		http.Error(w, "Failed to encode profile", http.StatusInternalServerError)
+	}
+}
+
+// This handler updates the display name and bio of the current user. Omitted fields are left unchanged.
+// On success, status 200 (OK) is returned along with the updated profile.
+func (u *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type UpdateProfileRequest struct {
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	profile, err := u.UserService.UpdateProfile(userID, req.DisplayName, req.Bio)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode profile: %v", err)
+		http.Error(w, "Failed to encode profile", http.StatusInternalServerError)
+	}
+}
+
+// This handler changes the handle of the current user.
+// On success, status 200 (OK) is returned along with the updated profile.
+func (u *UserHandler) ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type ChangeUsernameRequest struct {
+		Username string
+	}
+
+	var req ChangeUsernameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	profile, err := u.UserService.ChangeUsername(userID, req.Username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode profile: %v", err)
+		http.Error(w, "Failed to encode profile", http.StatusInternalServerError)
+	}
+}
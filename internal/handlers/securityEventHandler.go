@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"blog/internal/repository"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// This handler returns a page of the security log of the current user, newest first.
+// It accepts the "cursor", "limit" and "sort" (newest or oldest) query parameters.
+func (u *UserHandler) GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	page, err := parsePage(r.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := u.UserService.GetSecurityEvents(userID, page)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	setNextLink(w, r, events.NextCursor)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		log.Printf("Failed to encode security events: %v", err)
+		http.Error(w, "Failed to encode security events", http.StatusInternalServerError)
+	}
+}
+
+// This handler searches the security log of all users. It is available to admins only.
+// Besides the "cursor", "limit" and "sort" of the page, it filters by the query parameters "user_id", "type", "email", "ip",
+// and "since" and "until" as RFC 3339 timestamps.
+func (u *UserHandler) QuerySecurityEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := parsePage(query, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := repository.SecurityEventFilter{Page: page}
+	filter.Type = query.Get("type")
+	filter.Email = query.Get("email")
+	filter.IP = query.Get("ip")
+
+	if v := query.Get("user_id"); v != "" {
+		userID, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = &userID
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := query.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+param.name+", expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*param.value = t
+		}
+	}
+
+	events, err := u.UserService.QuerySecurityEvents(filter)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	setNextLink(w, r, events.NextCursor)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		log.Printf("Failed to encode security events: %v", err)
+		http.Error(w, "Failed to encode security events", http.StatusInternalServerError)
+	}
+}
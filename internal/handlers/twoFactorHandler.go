@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// This handler starts TOTP enrollment for the current user.
+// On success, status 200 (OK) is returned along with the secret and the otpauth URI for authenticator apps.
+func (u *UserHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	enrollment, err := u.UserService.BeginTOTPEnrollment(userID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		log.Printf("Failed to encode TOTP enrollment: %v", err)
+		http.Error(w, "Failed to encode TOTP enrollment", http.StatusInternalServerError)
+	}
+}
+
+// This handler confirms TOTP enrollment with the first code from the authenticator app.
+// The current session gets a new session cookie.
+// On success, status 200 (OK) is returned along with the recovery codes, which are shown only once.
+func (u *UserHandler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+
+	type ConfirmTwoFactorRequest struct {
+		Code string
+	}
+
+	var req ConfirmTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	codes, session, err := u.UserService.ConfirmTOTPEnrollment(userID, sessionID, req.Code, clientMeta(r))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	setSessionCookie(w, *session)
+
+	if err := json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes}); err != nil {
+		log.Printf("Failed to encode recovery codes: %v", err)
+		http.Error(w, "Failed to encode recovery codes", http.StatusInternalServerError)
+	}
+}
+
+// This handler turns off two-factor authentication for the current user after confirming the password.
+// On success, it returns status 204 (No Content).
+func (u *UserHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type DisableTwoFactorRequest struct {
+		Password string
+	}
+
+	var req DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.DisableTOTP(userID, req.Password, clientMeta(r)); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler completes a two-factor login by exchanging the challenge token from POST /login
+// and a TOTP or recovery code for a session.
+// On success, the session cookie is set and status 200 (OK) is returned along with user information.
+func (u *UserHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	type LoginTwoFactorRequest struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string
+	}
+
+	var req LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	meta := clientMeta(r)
+	result, err := u.UserService.CompleteTwoFactorLogin(req.ChallengeToken, req.Code, meta)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	u.writeLoginResult(w, result)
+}
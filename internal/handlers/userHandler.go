@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"blog/internal/models"
+	"blog/internal/repository"
 	"blog/internal/services"
+	"blog/utils"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
-	"time"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 )
 
 type UserHandler struct {
@@ -21,17 +30,25 @@ func NewUserHandler(userService *services.UserService) *UserHandler {
 // and if registration is successful, it returns status 201 (Created).
 // If the data is incorrect or an error occurs during registration, appropriate errors are returned.
 func (u *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+
+	type RegisterRequest struct {
+		Username string
+		Email    string
+		Password string
+	}
+
+	var req RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
-	err = u.UserService.RegisterUser(&user)
+	user := models.User{Username: req.Username, Email: req.Email, Password: req.Password}
+	err = u.UserService.RegisterUser(&user, clientMeta(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeUserError(w, err)
 		return
 	}
 
@@ -57,47 +74,427 @@ func (u *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	if err := u.UserService.VerifyEmail(req.Email, req.Code); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := u.UserService.VerifyEmail(req.Email, req.Code, clientMeta(r)); err != nil {
+		writeUserError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// This handler sends a new verification code to the email from the request.
+// It returns status 202 (Accepted) whether or not the account exists,
+// and 429 (Too Many Requests) with a Retry-After header if a code was sent too recently.
+func (u *UserHandler) ResendVerificationCode(w http.ResponseWriter, r *http.Request) {
+
+	type ResendCodeRequest struct {
+		Email string
+	}
+
+	var req ResendCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.ResendVerificationCode(req.Email); err != nil {
+		if errors.Is(err, services.ErrResendCooldown) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(repository.VerifyResendCooldown.Seconds())))
+		}
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 // This handler handles user login by checking the email and password.
+// With "remember_me", the session lasts up to 30 days and its cookie survives closing the browser.
 // On successful authentication, status 200 (OK) is returned along with user information.
+// If the user has two-factor authentication enabled, a challenge token is returned instead,
+// which must be exchanged for a session at POST /login/2fa.
 // In case of errors, appropriate error codes are returned.
 func (u *UserHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
 
 	type LoginRequest struct {
+		Email      string
+		Password   string
+		RememberMe bool `json:"remember_me"`
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	meta := clientMeta(r)
+	meta.RememberMe = req.RememberMe
+	result, err := u.UserService.LoginUser(req.Email, req.Password, meta)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	u.writeLoginResult(w, result)
+}
+
+// This handler returns the CSRF token of the current session.
+// Clients send it in the X-CSRF-Token header with every request that changes data.
+func (u *UserHandler) GetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Context().Value("sessionID").(string)
+
+	response := map[string]string{"csrf_token": utils.CSRFToken(sessionID)}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode CSRF token: %v", err)
+		http.Error(w, "Failed to encode CSRF token", http.StatusInternalServerError)
+	}
+}
+
+// writeLoginResult either sets the session cookie and returns the owner view of the user's profile,
+// or returns the challenge token if the login has to be confirmed with a second factor.
+func (u *UserHandler) writeLoginResult(w http.ResponseWriter, result *services.LoginResult) {
+	if result.ChallengeToken != "" {
+		response := map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     result.ChallengeToken,
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("Failed to encode login challenge: %v", err)
+			http.Error(w, "Failed to encode login challenge", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	setSessionCookie(w, result.IssuedSession)
+
+	profile, err := u.UserService.GetOwnProfile(result.User.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		log.Printf("Failed to encode user: %v", err)
+		http.Error(w, "Failed to encode user", http.StatusInternalServerError)
+	}
+}
+
+// This handler ends the current session and clears the session cookie.
+// On success, it returns status 204 (No Content).
+func (u *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+
+	if err := u.UserService.Logout(userID, sessionID, clientMeta(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler revokes every session of the current user, including the one used for the request.
+// On success, it clears the session cookie and returns status 204 (No Content).
+func (u *UserHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	if err := u.UserService.RevokeSessions(userID, "", clientMeta(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler returns all active sessions of the current user with their device metadata.
+// On success, status 200 (OK) is returned along with the list of sessions.
+func (u *UserHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+
+	sessions, err := u.UserService.ListSessions(userID, sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		log.Printf("Failed to encode sessions: %v", err)
+		http.Error(w, "Failed to encode sessions", http.StatusInternalServerError)
+	}
+}
+
+// This handler terminates one session of the current user by its ID from the URL.
+// If the current session is terminated, the session cookie is cleared as well.
+// On success, it returns status 204 (No Content), or 404 (Not Found) if there is no such session.
+func (u *UserHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+	id := chi.URLParam(r, "id")
+
+	session, err := u.UserService.DeleteSession(userID, id, clientMeta(r))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	if session.SessionID == sessionID {
+		clearSessionCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler starts password recovery for the email from the request.
+// It always returns status 202 (Accepted), so the response does not reveal whether the account exists.
+func (u *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	type ForgotPasswordRequest struct {
+		Email string
+	}
+
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.ForgotPassword(req.Email); err != nil {
+		log.Printf("Failed to start password reset: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// This handler sets a new password using the token from the password reset email.
+// On success, it returns status 204 (No Content). An invalid or used token results in 400 (Bad Request).
+func (u *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	type ResetPasswordRequest struct {
+		Token    string
+		Password string
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.ResetPassword(req.Token, req.Password, clientMeta(r)); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler changes the password of the current user.
+// The current password must be provided. All other sessions of the user are revoked,
+// and the current session gets a new session cookie. On success, it returns status 204 (No Content).
+func (u *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+
+	type ChangePasswordRequest struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	session, err := u.UserService.ChangePassword(userID, sessionID, req.CurrentPassword, req.NewPassword, clientMeta(r))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	setSessionCookie(w, *session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler starts an email change for the current user and sends a code to the new address.
+// The old address stays active until the code is confirmed. On success, it returns status 202 (Accepted).
+func (u *UserHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+
+	type ChangeEmailRequest struct {
 		Email    string
 		Password string
 	}
 
-	var req LoginRequest
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.RequestEmailChange(userID, req.Password, req.Email); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// This handler confirms a pending email change with the code sent to the new address.
+// The current session gets a new session cookie. On success, it returns status 204 (No Content).
+func (u *UserHandler) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(uuid.UUID)
+	sessionID := r.Context().Value("sessionID").(string)
+
+	type VerifyEmailChangeRequest struct {
+		Code string
+	}
+
+	var req VerifyEmailChangeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid JSON received: %v", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
 	}
 	defer r.Body.Close()
 
-	user, sessionID, err := u.UserService.LoginUser(req.Email, req.Password)
+	session, err := u.UserService.ConfirmEmailChange(userID, sessionID, req.Code, clientMeta(r))
 	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	setSessionCookie(w, *session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// This handler changes the role of the user from the URL. It is available to admins only.
+// On success, it returns status 204 (No Content).
+func (u *UserHandler) SetRole(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("userID").(uuid.UUID)
+	userIDstr := chi.URLParam(r, "userID")
+
+	type SetRoleRequest struct {
+		Role string
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Invalid JSON received: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := u.UserService.SetRole(userIDstr, req.Role, adminID, clientMeta(r)); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeUserError maps errors of the user service to HTTP status codes.
+func writeUserError(w http.ResponseWriter, err error) {
+	var lockoutErr *services.LockoutError
+	switch {
+	case errors.As(err, &lockoutErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockoutErr.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidTwoFactorCode),
+		errors.Is(err, services.ErrInvalidChallenge), errors.Is(err, services.ErrInvalidMagicLink):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, services.ErrWrongPassword), errors.Is(err, services.ErrEmailNotVerified),
+		errors.Is(err, services.ErrOIDCEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrEmailTaken), errors.Is(err, services.ErrTwoFactorEnabled),
+		errors.Is(err, services.ErrUsernameTaken), errors.Is(err, services.ErrOIDCAccountUnverified):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrResendCooldown), errors.Is(err, services.ErrTooManyAttempts),
+		errors.Is(err, services.ErrUsernameCooldown):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, services.ErrNoEmailChange), errors.Is(err, services.ErrWrongVerifyCode),
+		errors.Is(err, services.ErrCodeExpired), errors.Is(err, services.ErrNoTwoFactorPending),
+		errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrInvalidProfile),
+		errors.Is(err, services.ErrPasswordRequired), errors.Is(err, services.ErrInvalidResetToken),
+		errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrInvalidOIDCState):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidRole), errors.Is(err, services.ErrInvalidUsername),
+		errors.Is(err, services.ErrReservedUsername):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrSessionNotFound), errors.Is(err, services.ErrUserNotFound),
+		errors.Is(err, services.ErrMagicLinkDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrOIDCProvider):
+		http.Error(w, services.ErrOIDCProvider.Error(), http.StatusBadGateway)
+	default:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
+}
+
+// clientMeta describes the client that sent the request, for new sessions and the security log.
+func clientMeta(r *http.Request) models.SessionMeta {
+	return models.SessionMeta{IP: utils.ClientIP(r), UserAgent: r.UserAgent()}
+}
 
+// setSessionCookie sets the session cookie issued after a successful login or a session rotation.
+// Remember-me sessions get a persistent cookie, other sessions a cookie that is dropped when the browser is closed.
+// The CSRF token bound to the new session is sent along in the X-CSRF-Token header.
+func setSessionCookie(w http.ResponseWriter, session services.IssuedSession) {
+	cookie := sessionCookie(session.SessionID)
+	cookie.Expires = session.ExpiresAt
+	http.SetCookie(w, cookie)
+
+	w.Header().Set(utils.CSRFHeader, utils.CSRFToken(session.SessionID))
+}
+
+// clearSessionCookie tells the client to drop the session cookie.
+func clearSessionCookie(w http.ResponseWriter) {
+	cookie := sessionCookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// sessionCookie returns the session cookie with the attributes configured by
+// COOKIE_DOMAIN, COOKIE_PATH (default "/") and COOKIE_SAMESITE ("lax" by default, "strict" or "none").
+func sessionCookie(value string) *http.Cookie {
 	cookie := &http.Cookie{
 		Name:     "sessionID",
-		Value:    sessionID,
+		Value:    value,
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+		Path:     os.Getenv("COOKIE_PATH"),
 		HttpOnly: true,
 		Secure:   true,
-		Expires:  time.Now().Add(24 * time.Hour),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
 	}
-	http.SetCookie(w, cookie)
 
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Printf("Failed to encode user: %v", err)
-		http.Error(w, "Failed to encode user", http.StatusInternalServerError)
+	switch sameSite := strings.ToLower(os.Getenv("COOKIE_SAMESITE")); sameSite {
+	case "", "lax":
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		log.Printf("Unknown COOKIE_SAMESITE %q, using lax", sameSite)
 	}
+
+	return cookie
 }
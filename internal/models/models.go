@@ -1,35 +1,360 @@
 package models
 
 import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID         uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Username   string    `json:"username"`
-	Email      string    `gorm:"type:varchar(255);not null;unique" json:"email"`
-	Password   string    `gorm:"type:varchar(255);not null" json:"password"`
-	IsVerified bool      `gorm:"default:false" json:"is_verified"`
-	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	ID             uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Username       string    `gorm:"index:idx_users_username_lower,unique,expression:lower(username),where:username <> ''" json:"username"`
+	Email          string    `gorm:"type:varchar(255);not null;unique" json:"email"`
+	Password       string    `gorm:"type:varchar(255);not null" json:"-"`
+	IsVerified     bool      `gorm:"default:false" json:"is_verified"`
+	TOTPSecret     string    `gorm:"type:varchar(64)" json:"-"`
+	TOTPEnabled    bool      `gorm:"default:false" json:"totp_enabled"`
+	Role           string    `gorm:"type:varchar(20);not null;default:'user'" json:"role"`
+	DisplayName    string    `gorm:"type:varchar(100)" json:"display_name"`
+	Bio            string    `gorm:"type:text" json:"bio"`
+	AvatarURL      string    `gorm:"type:varchar(255)" json:"avatar_url"`
+	AvatarThumbURL string    `gorm:"type:varchar(255)" json:"avatar_thumb_url"`
+	// AvatarKey is the storage key prefix of the current avatar files, used to delete them on change.
+	AvatarKey string `gorm:"type:varchar(255)" json:"-"`
+	// UsernameChangedAt is used to enforce the cooldown between handle changes.
+	UsernameChangedAt *time.Time `json:"-"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// UsernameHistory keeps previous handles of users, so links with an old handle can be redirected
+// and the old handle is not taken over by someone else right away.
+type UsernameHistory struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Username  string    `gorm:"type:varchar(30);not null;index"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// UserIdentity links a user to their account at an external OpenID Connect provider.
+// The issuer and subject identify the external account; the email is the one the provider reported when it was linked.
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Issuer    string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_issuer_subject"`
+	Subject   string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_issuer_subject"`
+	Email     string    `gorm:"type:varchar(255)"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// PublicProfile is the part of a user that anyone can see.
+type PublicProfile struct {
+	ID             uuid.UUID `json:"id"`
+	Username       string    `json:"username"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	AvatarThumbURL string    `json:"avatar_thumb_url"`
+	JoinedAt       time.Time `json:"joined_at"`
+	PostCount      int64     `json:"post_count"`
+}
+
+// OwnerProfile is the view of a user that only the user themselves can see.
+type OwnerProfile struct {
+	PublicProfile
+	Email       string `json:"email"`
+	IsVerified  bool   `json:"is_verified"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	Role        string `json:"role"`
+}
+
+// PublicProfile returns the public view of the user.
+func (u *User) PublicProfile(postCount int64) PublicProfile {
+	return PublicProfile{
+		ID:             u.ID,
+		Username:       u.Username,
+		DisplayName:    u.DisplayName,
+		Bio:            u.Bio,
+		AvatarURL:      u.AvatarURL,
+		AvatarThumbURL: u.AvatarThumbURL,
+		JoinedAt:       u.CreatedAt,
+		PostCount:      postCount,
+	}
+}
+
+// OwnerProfile returns the owner-only view of the user.
+func (u *User) OwnerProfile(postCount int64) OwnerProfile {
+	return OwnerProfile{
+		PublicProfile: u.PublicProfile(postCount),
+		Email:         u.Email,
+		IsVerified:    u.IsVerified,
+		TOTPEnabled:   u.TOTPEnabled,
+		Role:          u.Role,
+	}
+}
+
+// AccountExport is everything stored about a user, handed out on request of the user.
+type AccountExport struct {
+	Profile  OwnerProfile `json:"profile"`
+	Posts    []Post       `json:"posts"`
+	Comments []Comment    `json:"comments"`
+	Sessions []Session    `json:"sessions"`
+}
+
+// User roles. Moderators can hide and delete any post or comment and edit any post, admins can also change roles.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user has lost their authenticator.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
 }
 
 type Post struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement" json:"post_id"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null" json:"-"`
+	ID        uint       `gorm:"primaryKey;autoIncrement;index:idx_posts_created,priority:2;index:idx_posts_published,priority:2" json:"post_id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null" json:"-"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Status    string     `gorm:"type:varchar(20);not null;default:published;index" json:"status"`
+	PublishAt *time.Time `gorm:"index" json:"publish_at,omitempty"`
+	// PublishedAt orders listings: the time the post was or will be published, or its creation time if it never was.
+	PublishedAt time.Time `gorm:"index:idx_posts_published,priority:1" json:"published_at"`
+	Hidden      bool      `gorm:"default:false" json:"-"`
+	CreatedAt   time.Time `gorm:"autoCreateTime;index:idx_posts_created,priority:1" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Post statuses. Other users see published posts, and scheduled posts once their publish_at has passed, in listings.
+// Unlisted posts are not listed but can be opened by anyone with the link; drafts and private posts only by their author.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostUnlisted  = "unlisted"
+	PostPrivate   = "private"
+)
+
+// IsPublished reports whether the post is listed for other users at the given time.
+func (p *Post) IsPublished(now time.Time) bool {
+	if p.Status == PostScheduled {
+		return p.PublishAt != nil && !p.PublishAt.After(now)
+	}
+	return p.Status == PostPublished
+}
+
+// VisibleTo reports whether the user can open the post at the given time.
+// The viewer is uuid.Nil for anonymous requests. Posts hidden by a moderator are visible to no one.
+func (p *Post) VisibleTo(viewerID uuid.UUID, now time.Time) bool {
+	if p.Hidden {
+		return false
+	}
+	return p.UserID == viewerID || p.IsPublished(now) || p.Status == PostUnlisted
+}
+
+// Page is one page of a list. NextCursor continues the list and is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PostRevision is a saved version of the title and content of a post.
+// Every edit adds a new revision, numbered from 1 for each post, so earlier versions are never overwritten.
+type PostRevision struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	PostID    uint      `gorm:"not null;uniqueIndex:idx_post_revision" json:"post_id"`
+	Revision  int       `gorm:"not null;uniqueIndex:idx_post_revision" json:"revision"`
+	EditorID  uuid.UUID `gorm:"type:uuid;not null" json:"editor_id"`
 	Title     string    `json:"title"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// NewRevision returns the current title and content of the post as the revision with the given number.
+func (p *Post) NewRevision(number int, editorID uuid.UUID) *PostRevision {
+	return &PostRevision{
+		PostID:   p.ID,
+		Revision: number,
+		EditorID: editorID,
+		Title:    p.Title,
+		Content:  p.Content,
+	}
+}
+
+// PostAuthor is the summary of the author shown together with a post.
+type PostAuthor struct {
+	ID             uuid.UUID `json:"id"`
+	Username       string    `json:"username"`
+	DisplayName    string    `json:"display_name"`
+	AvatarThumbURL string    `json:"avatar_thumb_url"`
+}
+
+// PostDetail is a single post together with its author and the number of its visible comments.
+type PostDetail struct {
+	Post
+	Author       PostAuthor `json:"author"`
+	CommentCount int64      `json:"comment_count"`
+}
+
+// PostAuthor returns the summary of the user shown as the author of their posts.
+func (u *User) PostAuthor() PostAuthor {
+	return PostAuthor{
+		ID:             u.ID,
+		Username:       u.Username,
+		DisplayName:    u.DisplayName,
+		AvatarThumbURL: u.AvatarThumbURL,
+	}
 }
 
 type Comment struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement" json:"comment_id"`
+	ID        uint      `gorm:"primaryKey;autoIncrement;index:idx_comments_post_created,priority:3" json:"comment_id"`
 	UserID    uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
-	PostId    uint      `gorm:"not null" json:"post_id"`
+	PostId    uint      `gorm:"not null;index:idx_comments_post_created,priority:1" json:"post_id"`
 	Content   string    `json:"content"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	Hidden    bool      `gorm:"default:false" json:"-"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_comments_post_created,priority:2" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
+
+// ModerationAction records a moderator hiding or deleting someone's post or comment.
+type ModerationAction struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"action_id"`
+	ModeratorID  uuid.UUID `gorm:"type:uuid;not null;index" json:"moderator_id"`
+	Action       string    `gorm:"type:varchar(20);not null" json:"action"`
+	TargetType   string    `gorm:"type:varchar(20);not null" json:"target_type"`
+	TargetID     uint      `gorm:"not null" json:"target_id"`
+	TargetUserID uuid.UUID `gorm:"type:uuid;not null" json:"target_user_id"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Moderation actions and their targets.
+const (
+	ModerationHide   = "hide"
+	ModerationUnhide = "unhide"
+	ModerationDelete = "delete"
+	ModerationEdit   = "edit"
+
+	TargetPost    = "post"
+	TargetComment = "comment"
+)
+
+// SecurityEvent records a security-relevant event of an account, such as a login or a password change.
+// The table is append-only: rows are never updated or deleted, not even when the account is deleted.
+// UserID is empty for events that cannot be tied to an account, e.g. a failed login with an unknown email.
+type SecurityEvent struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"event_id"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	Type      string     `gorm:"type:varchar(40);not null;index" json:"type"`
+	Email     string     `gorm:"type:varchar(255);index" json:"email,omitempty"`
+	IP        string     `gorm:"type:varchar(64);index" json:"ip,omitempty"`
+	UserAgent string     `gorm:"type:text" json:"user_agent,omitempty"`
+	Details   string     `gorm:"type:text" json:"details,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// Types of security events.
+const (
+	EventRegistered        = "registered"
+	EventEmailVerified     = "email_verified"
+	EventLoginSucceeded    = "login_succeeded"
+	EventLoginFailed       = "login_failed"
+	EventLockout           = "lockout"
+	EventTwoFactorFailed   = "two_factor_failed"
+	EventTwoFactorEnabled  = "two_factor_enabled"
+	EventTwoFactorDisabled = "two_factor_disabled"
+	EventPasswordChanged   = "password_changed"
+	EventPasswordReset     = "password_reset"
+	EventEmailChanged      = "email_changed"
+	EventSessionRevoked    = "session_revoked"
+	EventSessionsRevoked   = "sessions_revoked"
+	EventRoleChanged       = "role_changed"
+	EventAccountDeleted    = "account_deleted"
+)
+
+// Session is a login session stored in Redis.
+// ID is a public identifier derived from the session ID, so the secret cookie value is never exposed.
+type Session struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"-"`
+	UserID    uuid.UUID `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	// ExpiresAt is the end of the session's maximum lifetime. It ends earlier if it is idle for too long.
+	ExpiresAt  time.Time `json:"expires_at"`
+	RememberMe bool      `json:"remember_me"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+}
+
+// SessionMeta describes the client a session is created for.
+type SessionMeta struct {
+	IP        string
+	UserAgent string
+	// RememberMe asks for a long-lived session that survives closing the browser.
+	RememberMe bool
+}
+
+// Scopes that can be granted to personal access tokens.
+const (
+	ScopePostsWrite    = "posts:write"
+	ScopeCommentsWrite = "comments:write"
+)
+
+// AllScopes lists every scope a personal access token can be granted.
+var AllScopes = []string{ScopePostsWrite, ScopeCommentsWrite}
+
+// Scopes is a list of token scopes stored as a space separated string.
+type Scopes []string
+
+// Has reports whether the list contains the scope.
+func (s Scopes) Has(scope string) bool {
+	for _, v := range s {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *Scopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	case nil:
+		*s = nil
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", value)
+	}
+	return nil
+}
+
+// AccessToken is a personal access token for API and script clients.
+// Only the hash of the token is stored; the token itself is shown once on creation.
+type AccessToken struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"token_id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	Name       string     `gorm:"type:varchar(100);not null" json:"name"`
+	TokenHash  string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	Scopes     Scopes     `gorm:"type:varchar(255);not null" json:"scopes"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"blog/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenRepository(db *gorm.DB) *AccessTokenRepository {
+	return &AccessTokenRepository{db: db}
+}
+
+func (a *AccessTokenRepository) CreateToken(token *models.AccessToken) error {
+	return a.db.Create(token).Error
+}
+
+// GetTokensByUser returns a page of the tokens of the user, newest first unless the page is ascending.
+func (a *AccessTokenRepository) GetTokensByUser(userID uuid.UUID, page Page) ([]models.AccessToken, error) {
+	var tokens []models.AccessToken
+	err := a.db.Where("user_id = ?", userID).Scopes(paginate(page)).Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (a *AccessTokenRepository) GetTokenByHash(tokenHash string) (*models.AccessToken, error) {
+	var token models.AccessToken
+	err := a.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// TouchToken records when the token was last used.
+func (a *AccessTokenRepository) TouchToken(tokenID uint) error {
+	return a.db.Model(&models.AccessToken{}).Where("id = ?", tokenID).Update("last_used_at", time.Now()).Error
+}
+
+// DeleteToken revokes a token of the user. It returns false if the user has no such token.
+func (a *AccessTokenRepository) DeleteToken(tokenID uint, userID uuid.UUID) (bool, error) {
+	res := a.db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&models.AccessToken{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -19,9 +19,10 @@ func (c *CommentRepository) CreateComment(comment *models.Comment) error {
 	return c.db.Create(comment).Error
 }
 
-func (c *CommentRepository) GetCommentsByPostId(postID uint) ([]models.Comment, error) {
+// GetCommentsByPostId returns a page of the visible comments of the post.
+func (c *CommentRepository) GetCommentsByPostId(postID uint, page Page) ([]models.Comment, error) {
 	var comments []models.Comment
-	err := c.db.Where("post_id = ?", postID).Find(&comments).Error
+	err := c.db.Where("post_id = ? AND hidden = ?", postID, false).Scopes(paginate(page)).Find(&comments).Error
 	if err != nil {
 		return nil, err
 	}
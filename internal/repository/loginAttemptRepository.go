@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	loginFailureWindow    = 24 * time.Hour
+	loginFailureKeyPrefix = "login_fail:"
+	loginLockKeyPrefix    = "login_lock:"
+)
+
+// LoginAttemptRepository tracks failed login attempts and temporary lockouts in Redis.
+// Keys are opaque strings chosen by the caller, e.g. "email:<email>" or "ip:<ip>".
+type LoginAttemptRepository struct {
+	redis *redis.Client
+	ctx   context.Context
+}
+
+func NewLoginAttemptRepository(redis *redis.Client) *LoginAttemptRepository {
+	return &LoginAttemptRepository{
+		redis: redis,
+		ctx:   context.Background(),
+	}
+}
+
+// RegisterFailure counts a failed login attempt for the key and returns the number of failures in the current window.
+func (l *LoginAttemptRepository) RegisterFailure(key string) (int64, error) {
+	pipe := l.redis.TxPipeline()
+	incr := pipe.Incr(l.ctx, loginFailureKeyPrefix+key)
+	pipe.Expire(l.ctx, loginFailureKeyPrefix+key, loginFailureWindow)
+	if _, err := pipe.Exec(l.ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Lock blocks logins for the key for the given duration.
+func (l *LoginAttemptRepository) Lock(key string, duration time.Duration) error {
+	return l.redis.Set(l.ctx, loginLockKeyPrefix+key, 1, duration).Err()
+}
+
+// GetLockout returns how long logins for the key stay blocked, or zero if the key is not locked.
+func (l *LoginAttemptRepository) GetLockout(key string) (time.Duration, error) {
+	ttl, err := l.redis.PTTL(l.ctx, loginLockKeyPrefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Reset forgets the failed attempts of the key after a successful login.
+func (l *LoginAttemptRepository) Reset(key string) error {
+	return l.redis.Del(l.ctx, loginFailureKeyPrefix+key, loginLockKeyPrefix+key).Err()
+}
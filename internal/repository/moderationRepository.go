@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"blog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type ModerationRepository struct {
+	db *gorm.DB
+}
+
+func NewModerationRepository(db *gorm.DB) *ModerationRepository {
+	return &ModerationRepository{db: db}
+}
+
+func (m *ModerationRepository) GetPost(postID uint) (*models.Post, error) {
+	var post models.Post
+	err := m.db.Where("id = ?", postID).First(&post).Error
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (m *ModerationRepository) GetComment(commentID uint) (*models.Comment, error) {
+	var comment models.Comment
+	err := m.db.Where("id = ?", commentID).First(&comment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// SetPostHidden hides or unhides a post and records the action in the same transaction.
+func (m *ModerationRepository) SetPostHidden(postID uint, hidden bool, action *models.ModerationAction) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("id = ?", postID).Update("hidden", hidden).Error; err != nil {
+			return err
+		}
+		return tx.Create(action).Error
+	})
+}
+
+// DeletePost deletes a post with all of its comments and revisions and records the action in the same transaction.
+func (m *ModerationRepository) DeletePost(postID uint, action *models.ModerationAction) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("post_id = ?", postID).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("post_id = ?", postID).Delete(&models.PostRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", postID).Delete(&models.Post{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(action).Error
+	})
+}
+
+// SetCommentHidden hides or unhides a comment and records the action in the same transaction.
+func (m *ModerationRepository) SetCommentHidden(commentID uint, hidden bool, action *models.ModerationAction) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Comment{}).Where("id = ?", commentID).Update("hidden", hidden).Error; err != nil {
+			return err
+		}
+		return tx.Create(action).Error
+	})
+}
+
+// DeleteComment deletes a comment and records the action in the same transaction.
+func (m *ModerationRepository) DeleteComment(commentID uint, action *models.ModerationAction) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", commentID).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(action).Error
+	})
+}
+
+// GetActions returns a page of the moderation actions, newest first unless the page is ascending.
+func (m *ModerationRepository) GetActions(page Page) ([]models.ModerationAction, error) {
+	var actions []models.ModerationAction
+	err := m.db.Scopes(paginate(page)).Find(&actions).Error
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
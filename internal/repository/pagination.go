@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position in a list ordered by time and ID after which the next page starts.
+// The time is the creation time of the item, or the publication time for lists of posts.
+type Cursor struct {
+	Time time.Time
+	ID   uint
+}
+
+// String encodes the cursor as an opaque token for clients.
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(c.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a token returned by Cursor.String. It returns ErrInvalidCursor if the token is malformed.
+func ParseCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+	at, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	itemID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Time: time.Unix(0, at), ID: uint(itemID)}, nil
+}
+
+// Page selects one page of a list ordered by time and ID, newest first unless Ascending is set.
+// The list continues after the cursor After if it is set.
+type Page struct {
+	After     *Cursor
+	Limit     int
+	Ascending bool
+}
+
+// paginate orders a query by creation time and ID and limits it to the page.
+// It fetches one item more than the limit, so the caller can tell whether there is a next page.
+func paginate(page Page) func(*gorm.DB) *gorm.DB {
+	return paginateBy("created_at", page)
+}
+
+// paginateBy is paginate for lists ordered by another time column and ID.
+func paginateBy(column string, page Page) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		order, compare := "DESC", "<"
+		if page.Ascending {
+			order, compare = "ASC", ">"
+		}
+
+		if page.After != nil {
+			db = db.Where("("+column+", id) "+compare+" (?, ?)", page.After.Time, page.After.ID)
+		}
+		return db.Order(column + " " + order + ", id " + order).Limit(page.Limit + 1)
+	}
+}
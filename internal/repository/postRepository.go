@@ -2,9 +2,12 @@ package repository
 
 import (
 	"blog/internal/models"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PostRepository struct {
@@ -17,19 +20,173 @@ func NewPostRepository(db *gorm.DB) *PostRepository {
 	}
 }
 
+// CreatePost creates the post and stores its title and content as the first revision.
 func (p *PostRepository) CreatePost(post *models.Post) error {
-	return p.db.Create(post).Error
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+		return tx.Create(post.NewRevision(1, post.UserID)).Error
+	})
 }
 
-func (p *PostRepository) GetPosts(userID uuid.UUID) ([]models.Post, error) {
+// GetPosts returns a page of the posts of the user that are not hidden, newest publication first.
+// Unless all is set, only the posts listed for other users at the given time are returned.
+func (p *PostRepository) GetPosts(userID uuid.UUID, all bool, now time.Time, page Page) ([]models.Post, error) {
+	query := p.db.Where("user_id = ? AND hidden = ?", userID, false)
+	if !all {
+		query = query.Scopes(publishedPosts(now))
+	}
+
 	var posts []models.Post
-	err := p.db.Where("user_id = ?", userID).Find(&posts).Error
+	err := query.Scopes(paginateBy("published_at", page)).Find(&posts).Error
 	if err != nil {
 		return nil, err
 	}
 	return posts, nil
 }
 
+// GetFeed returns a page of the posts of all users that are listed for other users at the given time, newest publication first.
+func (p *PostRepository) GetFeed(now time.Time, page Page) ([]models.Post, error) {
+	var posts []models.Post
+	err := p.db.Where("hidden = ?", false).Scopes(publishedPosts(now), paginateBy("published_at", page)).Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// publishedPosts limits a query to posts that are listed for other users at the given time:
+// published posts and scheduled posts whose publication time has passed.
+func publishedPosts(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(status = ? OR (status = ? AND publish_at <= ?))", models.PostPublished, models.PostScheduled, now)
+	}
+}
+
+// PublishDuePosts marks scheduled posts whose publication time has passed as published,
+// recording that time as the time they were published. It returns the number of published posts.
+func (p *PostRepository) PublishDuePosts(now time.Time) (int64, error) {
+	result := p.db.Model(&models.Post{}).
+		Where("status = ? AND publish_at <= ?", models.PostScheduled, now).
+		Updates(map[string]interface{}{"status": models.PostPublished, "published_at": gorm.Expr("publish_at")})
+	return result.RowsAffected, result.Error
+}
+
+// BackfillPublishedAt sets the publication time of posts created before it was recorded:
+// their publish_at if they have one, otherwise their creation time.
+func (p *PostRepository) BackfillPublishedAt() (int64, error) {
+	result := p.db.Model(&models.Post{}).
+		Where("published_at IS NULL").
+		Update("published_at", gorm.Expr("COALESCE(publish_at, created_at)"))
+	return result.RowsAffected, result.Error
+}
+
+// NextPublishAt returns the earliest publication time of the scheduled posts, or nil if no post is scheduled.
+func (p *PostRepository) NextPublishAt() (*time.Time, error) {
+	var next sql.NullTime
+	err := p.db.Model(&models.Post{}).Select("MIN(publish_at)").Where("status = ?", models.PostScheduled).Scan(&next).Error
+	if err != nil || !next.Valid {
+		return nil, err
+	}
+	return &next.Time, nil
+}
+
+// GetPost returns the post with the given ID, including hidden posts.
+func (p *PostRepository) GetPost(postID uint) (*models.Post, error) {
+	var post models.Post
+	err := p.db.Where("id = ?", postID).First(&post).Error
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// CountCommentsByPost returns the number of visible comments of each of the posts.
+// Posts without comments are missing from the result.
+func (p *PostRepository) CountCommentsByPost(postIDs []uint) (map[uint]int64, error) {
+	var rows []struct {
+		PostID uint
+		Count  int64
+	}
+	err := p.db.Model(&models.Comment{}).Select("post_id, COUNT(*) AS count").
+		Where("post_id IN ? AND hidden = ?", postIDs, false).Group("post_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.PostID] = row.Count
+	}
+	return counts, nil
+}
+
+// UpdatePost saves the title, content, status and publication time of the post.
+// A changed title or content is stored as a new revision by the editor;
+// posts created before revisions were kept first get their previous version stored as revision 1.
+// If action is not nil, it is recorded in the same transaction, as the edit was made by a moderator.
+func (p *PostRepository) UpdatePost(post *models.Post, editorID uuid.UUID, action *models.ModerationAction) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the post serializes concurrent edits, so revision numbers are not taken twice.
+		var current models.Post
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", post.ID).First(&current).Error; err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{
+			"title":        post.Title,
+			"content":      post.Content,
+			"status":       post.Status,
+			"publish_at":   post.PublishAt,
+			"published_at": post.PublishedAt,
+		}
+		if err := tx.Model(&models.Post{}).Where("id = ?", post.ID).Updates(fields).Error; err != nil {
+			return err
+		}
+
+		if post.Title != current.Title || post.Content != current.Content {
+			var last int
+			if err := tx.Model(&models.PostRevision{}).Select("COALESCE(MAX(revision), 0)").Where("post_id = ?", post.ID).Scan(&last).Error; err != nil {
+				return err
+			}
+			if last == 0 {
+				first := current.NewRevision(1, current.UserID)
+				first.CreatedAt = current.UpdatedAt
+				if err := tx.Create(first).Error; err != nil {
+					return err
+				}
+				last = 1
+			}
+			if err := tx.Create(post.NewRevision(last+1, editorID)).Error; err != nil {
+				return err
+			}
+		}
+
+		if action == nil {
+			return nil
+		}
+		return tx.Create(action).Error
+	})
+}
+
+// GetRevisions returns all revisions of the post, newest first.
+func (p *PostRepository) GetRevisions(postID uint) ([]models.PostRevision, error) {
+	var revisions []models.PostRevision
+	err := p.db.Where("post_id = ?", postID).Order("revision DESC").Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// DeletePost deletes the post of the user together with its revisions.
 func (p *PostRepository) DeletePost(postID uint, userID uuid.UUID) error {
-	return p.db.Where("id = ? AND user_id = ?", postID, userID).Delete(&models.Post{}).Error
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", postID, userID).Delete(&models.Post{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Where("post_id = ?", postID).Delete(&models.PostRevision{}).Error
+	})
 }
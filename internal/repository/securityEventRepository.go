@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"blog/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SecurityEventRepository struct {
+	db *gorm.DB
+}
+
+func NewSecurityEventRepository(db *gorm.DB) *SecurityEventRepository {
+	return &SecurityEventRepository{db: db}
+}
+
+// SecurityEventFilter selects one page of the security events. Zero fields do not filter.
+type SecurityEventFilter struct {
+	UserID *uuid.UUID
+	Type   string
+	Email  string
+	IP     string
+	Since  time.Time
+	Until  time.Time
+	Page   Page
+}
+
+// MakeAppendOnly installs a trigger that rejects updates and deletes of security events,
+// so the log cannot be changed through the application, even by mistake.
+// The only update allowed is pseudonymization: clearing the email, IP, user agent and details of an event
+// while its ID, user, type and time stay the same, as PseudonymizeUserEvents does when an account is deleted.
+func (s *SecurityEventRepository) MakeAppendOnly() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`CREATE OR REPLACE FUNCTION security_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'UPDATE' AND NEW.id = OLD.id AND NEW.user_id IS NOT DISTINCT FROM OLD.user_id
+					AND NEW.type = OLD.type AND NEW.created_at = OLD.created_at
+					AND NEW.email = '' AND NEW.ip = '' AND NEW.user_agent = '' AND NEW.details = '' THEN
+					RETURN NEW;
+				END IF;
+				RAISE EXCEPTION 'security_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS security_events_append_only ON security_events`,
+			`CREATE TRIGGER security_events_append_only BEFORE UPDATE OR DELETE ON security_events
+			FOR EACH ROW EXECUTE FUNCTION security_events_append_only()`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SecurityEventRepository) CreateEvent(event *models.SecurityEvent) error {
+	return s.db.Create(event).Error
+}
+
+// GetEvents returns a page of the events matching the filter, newest first unless the page is ascending.
+func (s *SecurityEventRepository) GetEvents(filter SecurityEventFilter) ([]models.SecurityEvent, error) {
+	query := s.db.Scopes(paginate(filter.Page))
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	var events []models.SecurityEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// PseudonymizeUserEvents clears the email, IP, user agent and details of the security events of the user
+// and of events recorded for their email address. The user ID and type of each event are kept,
+// so the log still shows what happened to the account without identifying the person.
+// It takes the database or transaction to run in, so it can be part of deleting the account.
+func PseudonymizeUserEvents(db *gorm.DB, userID uuid.UUID, email string) error {
+	return db.Model(&models.SecurityEvent{}).Where("user_id = ? OR email = ?", userID, email).
+		Updates(map[string]interface{}{"email": "", "ip": "", "user_agent": "", "details": ""}).Error
+}
@@ -2,13 +2,62 @@ package repository
 
 import (
 	"blog/internal/models"
+	"blog/utils"
 	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// VerifyResendCooldown is the minimum time between two verification codes sent to the same email.
+const VerifyResendCooldown = time.Minute
+
+// Session lifetimes. A session ends when it was idle for longer than its idle timeout,
+// or at the latest when its maximum lifetime is over, however active it is.
+const (
+	SessionIdleTimeout  = 2 * time.Hour
+	SessionMaxLifetime  = 24 * time.Hour
+	RememberIdleTimeout = 14 * 24 * time.Hour
+	RememberMaxLifetime = 30 * 24 * time.Hour
+)
+
+const (
+	verifyCodeTTL           = 10 * time.Minute
+	verifyAttemptsKeyPrefix = "verify_attempts:"
+	verifyCooldownKeyPrefix = "verify_cooldown:"
+
+	sessionKeyPrefix      = "session:"
+	userSessionsKeyPrefix = "user_sessions:"
+
+	passwordResetTTL       = 30 * time.Minute
+	passwordResetKeyPrefix = "password_reset:"
+
+	emailChangeTTL               = 10 * time.Minute
+	emailChangeKeyPrefix         = "email_change:"
+	emailChangeAttemptsKeyPrefix = "email_change_attempts:"
+
+	totpPendingTTL          = 10 * time.Minute
+	totpPendingKeyPrefix    = "totp_pending:"
+	totpUsedKeyPrefix       = "totp_used:"
+	loginChallengeTTL       = 5 * time.Minute
+	loginChallengeKeyPrefix = "login_challenge:"
+
+	magicLinkTTL            = 15 * time.Minute
+	magicLinkKeyPrefix      = "magic_link:"
+	magicLinkCooldown       = time.Minute
+	magicLinkCooldownPrefix = "magic_link_cooldown:"
+
+	oidcStateKeyPrefix = "oidc_state:"
+)
+
+// OIDCStateTTL is how long a login started with an external provider can be completed.
+const OIDCStateTTL = 10 * time.Minute
+
 type UserRepository struct {
 	db           *gorm.DB
 	redisSession *redis.Client
@@ -25,6 +74,25 @@ func NewUserRepository(db *gorm.DB, redisSession, redisCode *redis.Client) *User
 	}
 }
 
+// RenameConflictingUsernames prepares existing users for the case-insensitive unique index on handles.
+// Of handles that are equal ignoring case, the oldest account keeps its handle; the others and all reserved handles
+// get a suffix from the user ID, so "Alice" becomes "Alice_1f2e3d4c". It must run before AutoMigrate creates the index,
+// and returns the number of renamed users.
+func RenameConflictingUsernames(db *gorm.DB, reserved []string) (int64, error) {
+	if !db.Migrator().HasColumn(&models.User{}, "Username") {
+		return 0, nil
+	}
+
+	result := db.Exec(`UPDATE users SET username = left(username, 21) || '_' || left(replace(id::text, '-', ''), 8)
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY lower(username) ORDER BY created_at, id) AS n
+				FROM users WHERE username <> ''
+			) ranked WHERE n > 1
+		) OR lower(username) IN ?`, reserved)
+	return result.RowsAffected, result.Error
+}
+
 func (u *UserRepository) CreateUser(user *models.User) error {
 	return u.db.Create(user).Error
 }
@@ -38,12 +106,144 @@ func (u *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
+func (u *UserRepository) GetUserByID(userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := u.db.Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUsersByIDs returns the users with the given IDs. Unknown IDs are skipped.
+func (u *UserRepository) GetUsersByIDs(userIDs []uuid.UUID) ([]models.User, error) {
+	var users []models.User
+	err := u.db.Where("id IN ?", userIDs).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetUserByUsername finds the user whose current handle matches the username, ignoring case.
+func (u *UserRepository) GetUserByUsername(username string) (*models.User, error) {
+	var user models.User
+	err := u.db.Where("lower(username) = lower(?)", username).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByPreviousUsername finds the user who used the username as a handle after the given time, ignoring case.
+func (u *UserRepository) GetUserByPreviousUsername(username string, since time.Time) (*models.User, error) {
+	var user models.User
+	err := u.db.Joins("JOIN username_histories ON username_histories.user_id = users.id").
+		Where("username_histories.username = lower(?) AND username_histories.created_at > ?", username, since).
+		Order("username_histories.created_at DESC").
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangeUsername sets a new handle for the user and keeps the old one in the history in a single transaction.
+func (u *UserRepository) ChangeUsername(userID uuid.UUID, oldUsername, newUsername string) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		if oldUsername != "" {
+			history := models.UsernameHistory{UserID: userID, Username: strings.ToLower(oldUsername)}
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"username": newUsername, "username_changed_at": time.Now()}).Error
+	})
+}
+
+// CountPosts returns the number of visible posts of the user, counting only posts listed for other users.
+func (u *UserRepository) CountPosts(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := u.db.Model(&models.Post{}).Where("user_id = ? AND hidden = ?", userID, false).Scopes(publishedPosts(time.Now())).Count(&count).Error
+	return count, err
+}
+
+// GetUserContent returns all posts and comments written by the user, including hidden ones.
+func (u *UserRepository) GetUserContent(userID uuid.UUID) ([]models.Post, []models.Comment, error) {
+	var posts []models.Post
+	if err := u.db.Where("user_id = ?", userID).Order("created_at").Find(&posts).Error; err != nil {
+		return nil, nil, err
+	}
+	var comments []models.Comment
+	if err := u.db.Where("user_id = ?", userID).Order("created_at").Find(&comments).Error; err != nil {
+		return nil, nil, err
+	}
+	return posts, comments, nil
+}
+
+// DeleteUser removes the user together with everything they own in one transaction:
+// their posts (with all comments on them), their comments on other posts, access tokens,
+// recovery codes and previous handles. Moderation actions are kept as an audit log,
+// and the security events of the user are kept without their personal data.
+func (u *UserRepository) DeleteUser(userID uuid.UUID, email string) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		if err := PseudonymizeUserEvents(tx, userID, email); err != nil {
+			return err
+		}
+		postIDs := tx.Model(&models.Post{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("post_id IN (?) OR user_id = ?", postIDs, userID).Delete(&models.Comment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("post_id IN (?)", postIDs).Delete(&models.PostRevision{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Post{}, &models.AccessToken{}, &models.RecoveryCode{}, &models.UsernameHistory{}, &models.UserIdentity{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id = ?", userID).Delete(&models.User{}).Error
+	})
+}
+
+// DeleteUserCodes removes every pending code of the user from Redis:
+// the verification code of the email, a pending email change and a pending TOTP enrollment.
+func (u *UserRepository) DeleteUserCodes(userID, email string) error {
+	return u.redisCode.Del(u.ctx,
+		email, verifyAttemptsKeyPrefix+email, verifyCooldownKeyPrefix+email,
+		emailChangeKeyPrefix+userID, emailChangeAttemptsKeyPrefix+userID, totpPendingKeyPrefix+userID,
+	).Err()
+}
+
+// UpdateProfile updates the given profile columns of the user.
+func (u *UserRepository) UpdateProfile(userID uuid.UUID, fields map[string]interface{}) error {
+	return u.db.Model(&models.User{}).Where("id = ?", userID).Updates(fields).Error
+}
+
 func (u *UserRepository) UpdateUser(user *models.User) error {
 	return u.db.Model(user).Updates(user).Error
 }
 
+func (u *UserRepository) UpdateRole(userID uuid.UUID, role string) error {
+	return u.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
+}
+
+func (u *UserRepository) MarkVerified(userID uuid.UUID) error {
+	return u.db.Model(&models.User{}).Where("id = ?", userID).Update("is_verified", true).Error
+}
+
+func (u *UserRepository) UpdatePassword(userID uuid.UUID, hashedPassword string) error {
+	return u.db.Model(&models.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error
+}
+
+// CreateCode stores a new verification code for the email and resets the counter of wrong guesses.
 func (u *UserRepository) CreateCode(email, code string) error {
-	return u.redisCode.Set(u.ctx, email, code, 10*time.Minute).Err()
+	pipe := u.redisCode.TxPipeline()
+	pipe.Set(u.ctx, email, code, verifyCodeTTL)
+	pipe.Del(u.ctx, verifyAttemptsKeyPrefix+email)
+	_, err := pipe.Exec(u.ctx)
+	return err
 }
 
 func (u *UserRepository) GetCodeByEmail(email string) (string, error) {
@@ -54,14 +254,449 @@ func (u *UserRepository) GetCodeByEmail(email string) (string, error) {
 	return code, nil
 }
 
-func (u *UserRepository) CreateSessionID(sessionID, userID string) error {
-	return u.redisSession.Set(u.ctx, sessionID, userID, 24*time.Hour).Err()
+// DeleteCode removes the verification code of the email together with its attempt counter.
+func (u *UserRepository) DeleteCode(email string) error {
+	return u.redisCode.Del(u.ctx, email, verifyAttemptsKeyPrefix+email).Err()
+}
+
+// IncrementCodeAttempts counts a wrong guess of the verification code and returns the number of wrong guesses so far.
+func (u *UserRepository) IncrementCodeAttempts(email string) (int64, error) {
+	pipe := u.redisCode.TxPipeline()
+	incr := pipe.Incr(u.ctx, verifyAttemptsKeyPrefix+email)
+	pipe.Expire(u.ctx, verifyAttemptsKeyPrefix+email, verifyCodeTTL)
+	if _, err := pipe.Exec(u.ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// StartResendCooldown starts the cooldown for resending a verification code to the email.
+// It returns false if the cooldown is already running.
+func (u *UserRepository) StartResendCooldown(email string) (bool, error) {
+	return u.redisCode.SetNX(u.ctx, verifyCooldownKeyPrefix+email, 1, VerifyResendCooldown).Result()
+}
+
+func (u *UserRepository) UpdateEmail(userID uuid.UUID, email string) error {
+	return u.db.Model(&models.User{}).Where("id = ?", userID).Update("email", email).Error
+}
+
+// CreateEmailChange stores a pending email change together with the code sent to the new address.
+// A new request replaces the previous one.
+func (u *UserRepository) CreateEmailChange(userID, email, code string) error {
+	pipe := u.redisCode.TxPipeline()
+	pipe.Del(u.ctx, emailChangeKeyPrefix+userID, emailChangeAttemptsKeyPrefix+userID)
+	pipe.HSet(u.ctx, emailChangeKeyPrefix+userID, "email", email, "code", code)
+	pipe.Expire(u.ctx, emailChangeKeyPrefix+userID, emailChangeTTL)
+	_, err := pipe.Exec(u.ctx)
+	return err
+}
+
+// GetEmailChange returns the pending new email and its confirmation code.
+// It returns redis.Nil if there is no pending change.
+func (u *UserRepository) GetEmailChange(userID string) (string, string, error) {
+	fields, err := u.redisCode.HGetAll(u.ctx, emailChangeKeyPrefix+userID).Result()
+	if err != nil {
+		return "", "", err
+	}
+	if len(fields) == 0 {
+		return "", "", redis.Nil
+	}
+	return fields["email"], fields["code"], nil
+}
+
+// IncrementEmailChangeAttempts counts a wrong guess of the email change code and returns the number of wrong guesses so far.
+func (u *UserRepository) IncrementEmailChangeAttempts(userID string) (int64, error) {
+	pipe := u.redisCode.TxPipeline()
+	incr := pipe.Incr(u.ctx, emailChangeAttemptsKeyPrefix+userID)
+	pipe.Expire(u.ctx, emailChangeAttemptsKeyPrefix+userID, emailChangeTTL)
+	if _, err := pipe.Exec(u.ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// DeleteEmailChange removes the pending email change of the user together with its attempt counter.
+func (u *UserRepository) DeleteEmailChange(userID string) error {
+	return u.redisCode.Del(u.ctx, emailChangeKeyPrefix+userID, emailChangeAttemptsKeyPrefix+userID).Err()
+}
+
+// SetPendingTOTPSecret stores a TOTP secret until the user confirms the enrollment with a first code.
+func (u *UserRepository) SetPendingTOTPSecret(userID, secret string) error {
+	return u.redisCode.Set(u.ctx, totpPendingKeyPrefix+userID, secret, totpPendingTTL).Err()
+}
+
+func (u *UserRepository) GetPendingTOTPSecret(userID string) (string, error) {
+	secret, err := u.redisCode.Get(u.ctx, totpPendingKeyPrefix+userID).Result()
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// EnableTOTP saves the confirmed TOTP secret and replaces the user's recovery codes in a single transaction.
+func (u *UserRepository) EnableTOTP(userID uuid.UUID, secret string, recoveryCodeHashes []string) error {
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": true}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]models.RecoveryCode, len(recoveryCodeHashes))
+		for i, hash := range recoveryCodeHashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return u.redisCode.Del(u.ctx, totpPendingKeyPrefix+userID.String()).Err()
+}
+
+// DisableTOTP removes the TOTP secret and all recovery codes of the user.
+func (u *UserRepository) DisableTOTP(userID uuid.UUID) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used.
+// It returns false if there is no such unused code.
+func (u *UserRepository) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	res := u.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// MarkTOTPStepUsed remembers that a TOTP code of the given time step was used,
+// so the same code cannot be replayed. It returns false if the step was already used.
+func (u *UserRepository) MarkTOTPStepUsed(userID string, step int64) (bool, error) {
+	key := totpUsedKeyPrefix + userID + ":" + strconv.FormatInt(step, 10)
+	return u.redisCode.SetNX(u.ctx, key, 1, 5*time.Minute).Result()
+}
+
+// CreateLoginChallenge stores the hash of a challenge token issued after the password step of a two-factor login.
+// The remember-me choice of the password step is kept for the session created after the second factor.
+func (u *UserRepository) CreateLoginChallenge(tokenHash, userID string, rememberMe bool) error {
+	pipe := u.redisCode.TxPipeline()
+	pipe.HSet(u.ctx, loginChallengeKeyPrefix+tokenHash, "user_id", userID, "attempts", 0, "remember", rememberMe)
+	pipe.Expire(u.ctx, loginChallengeKeyPrefix+tokenHash, loginChallengeTTL)
+	_, err := pipe.Exec(u.ctx)
+	return err
+}
+
+// GetLoginChallenge returns the user ID and remember-me choice of the challenge.
+// It returns redis.Nil if the challenge does not exist.
+func (u *UserRepository) GetLoginChallenge(tokenHash string) (string, bool, error) {
+	fields, err := u.redisCode.HGetAll(u.ctx, loginChallengeKeyPrefix+tokenHash).Result()
+	if err != nil {
+		return "", false, err
+	}
+	if len(fields) == 0 {
+		return "", false, redis.Nil
+	}
+	return fields["user_id"], fields["remember"] == "1", nil
+}
+
+// IncrementLoginChallengeAttempts counts a wrong second-factor code and returns the number of wrong codes so far.
+func (u *UserRepository) IncrementLoginChallengeAttempts(tokenHash string) (int64, error) {
+	return u.redisCode.HIncrBy(u.ctx, loginChallengeKeyPrefix+tokenHash, "attempts", 1).Result()
+}
+
+func (u *UserRepository) DeleteLoginChallenge(tokenHash string) error {
+	return u.redisCode.Del(u.ctx, loginChallengeKeyPrefix+tokenHash).Err()
+}
+
+// GetUserByIdentity returns the user linked to the external account. It returns gorm.ErrRecordNotFound if none is linked.
+func (u *UserRepository) GetUserByIdentity(issuer, subject string) (*models.User, error) {
+	var identity models.UserIdentity
+	if err := u.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return u.GetUserByID(identity.UserID)
+}
+
+// CreateIdentity links an external account to an existing user.
+func (u *UserRepository) CreateIdentity(identity *models.UserIdentity) error {
+	return u.db.Create(identity).Error
+}
+
+// CreateUserWithIdentity creates a user who signed up with an external account, together with the link to it.
+func (u *UserRepository) CreateUserWithIdentity(user *models.User, identity *models.UserIdentity) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+// CreateOIDCState stores the PKCE verifier and nonce of a login started with an external provider under its state.
+func (u *UserRepository) CreateOIDCState(state, verifier, nonce string) error {
+	pipe := u.redisCode.TxPipeline()
+	pipe.HSet(u.ctx, oidcStateKeyPrefix+state, "verifier", verifier, "nonce", nonce)
+	pipe.Expire(u.ctx, oidcStateKeyPrefix+state, OIDCStateTTL)
+	_, err := pipe.Exec(u.ctx)
+	return err
+}
+
+// ConsumeOIDCState returns the PKCE verifier and nonce of the state and deletes it, so each state is used once.
+// It returns redis.Nil if the state is unknown, expired or was already used.
+func (u *UserRepository) ConsumeOIDCState(state string) (string, string, error) {
+	pipe := u.redisCode.TxPipeline()
+	get := pipe.HGetAll(u.ctx, oidcStateKeyPrefix+state)
+	pipe.Del(u.ctx, oidcStateKeyPrefix+state)
+	if _, err := pipe.Exec(u.ctx); err != nil {
+		return "", "", err
+	}
+	fields := get.Val()
+	if len(fields) == 0 {
+		return "", "", redis.Nil
+	}
+	return fields["verifier"], fields["nonce"], nil
+}
+
+// CreateMagicLink stores the hash of a single-use login link token for the user.
+func (u *UserRepository) CreateMagicLink(tokenHash, userID string) error {
+	return u.redisCode.Set(u.ctx, magicLinkKeyPrefix+tokenHash, userID, magicLinkTTL).Err()
+}
+
+// ConsumeMagicLink returns the user ID of the login link token and deletes it, so the link works only once.
+// It returns redis.Nil if the token is unknown, expired or was already used.
+func (u *UserRepository) ConsumeMagicLink(tokenHash string) (string, error) {
+	return u.redisCode.GetDel(u.ctx, magicLinkKeyPrefix+tokenHash).Result()
+}
+
+// StartMagicLinkCooldown limits how often login links are sent to the same email.
+// It returns false if a link was sent recently.
+func (u *UserRepository) StartMagicLinkCooldown(email string) (bool, error) {
+	return u.redisCode.SetNX(u.ctx, magicLinkCooldownPrefix+email, 1, magicLinkCooldown).Result()
+}
+
+// CreatePasswordResetToken stores the hash of a password reset token for the user.
+func (u *UserRepository) CreatePasswordResetToken(tokenHash, userID string) error {
+	return u.redisCode.Set(u.ctx, passwordResetKeyPrefix+tokenHash, userID, passwordResetTTL).Err()
+}
+
+// ConsumePasswordResetToken returns the user ID the token was issued for and deletes the token,
+// so each token can be used only once.
+func (u *UserRepository) ConsumePasswordResetToken(tokenHash string) (string, error) {
+	userID, err := u.redisCode.GetDel(u.ctx, passwordResetKeyPrefix+tokenHash).Result()
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// touchSessionScript refreshes last_seen of an existing session and returns all of its fields,
+// so the middleware needs a single round trip per request.
+// It also slides the idle timeout of the session, but never past its maximum lifetime,
+// and deletes the session once that lifetime is over.
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return nil
+end
+local now = tonumber(ARGV[1])
+local expires_at = tonumber(redis.call("HGET", KEYS[1], "expires_at"))
+local idle_timeout = tonumber(redis.call("HGET", KEYS[1], "idle_timeout"))
+if expires_at and idle_timeout then
+	if now >= expires_at then
+		redis.call("DEL", KEYS[1])
+		return nil
+	end
+	redis.call("EXPIRE", KEYS[1], math.min(idle_timeout, expires_at - now))
+end
+redis.call("HSET", KEYS[1], "last_seen", ARGV[1])
+return redis.call("HGETALL", KEYS[1])
+`)
+
+// CreateSessionID stores the session record and adds it to the per-user session index,
+// so that all sessions of a user can be found and revoked later.
+// Remember-me sessions get the longer idle timeout and lifetime. It returns when the session ends at the latest.
+func (u *UserRepository) CreateSessionID(sessionID, userID string, meta models.SessionMeta) (time.Time, error) {
+	idleTimeout, maxLifetime := SessionIdleTimeout, SessionMaxLifetime
+	remember := "0"
+	if meta.RememberMe {
+		idleTimeout, maxLifetime = RememberIdleTimeout, RememberMaxLifetime
+		remember = "1"
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(maxLifetime)
+
+	pipe := u.redisSession.TxPipeline()
+	pipe.HSet(u.ctx, sessionKeyPrefix+sessionID,
+		"user_id", userID,
+		"created_at", strconv.FormatInt(now.Unix(), 10),
+		"last_seen", strconv.FormatInt(now.Unix(), 10),
+		"expires_at", strconv.FormatInt(expiresAt.Unix(), 10),
+		"idle_timeout", strconv.FormatInt(int64(idleTimeout.Seconds()), 10),
+		"remember", remember,
+		"ip", meta.IP,
+		"user_agent", meta.UserAgent,
+	)
+	pipe.Expire(u.ctx, sessionKeyPrefix+sessionID, idleTimeout)
+	pipe.SAdd(u.ctx, userSessionsKeyPrefix+userID, sessionID)
+	// The index outlives every session in it, expired members are dropped by GetUserSessions.
+	pipe.Expire(u.ctx, userSessionsKeyPrefix+userID, RememberMaxLifetime)
+	_, err := pipe.Exec(u.ctx)
+	return expiresAt, err
+}
+
+// RotateSession moves a session to a new session ID, keeping its data, idle timeout and maximum lifetime.
+// The old ID stops working immediately. It returns redis.Nil if the old session does not exist.
+func (u *UserRepository) RotateSession(oldSessionID, newSessionID string) (*models.Session, error) {
+	userID, err := u.GetUserIdBySession(oldSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := u.redisSession.TxPipeline()
+	pipe.Rename(u.ctx, sessionKeyPrefix+oldSessionID, sessionKeyPrefix+newSessionID)
+	pipe.SRem(u.ctx, userSessionsKeyPrefix+userID, oldSessionID)
+	pipe.SAdd(u.ctx, userSessionsKeyPrefix+userID, newSessionID)
+	fields := pipe.HGetAll(u.ctx, sessionKeyPrefix+newSessionID)
+	if _, err := pipe.Exec(u.ctx); err != nil {
+		return nil, err
+	}
+
+	return parseSession(newSessionID, fields.Val())
 }
 
 func (u *UserRepository) GetUserIdBySession(sessionID string) (string, error) {
-	userID, err := u.redisSession.Get(u.ctx, sessionID).Result()
+	userID, err := u.redisSession.HGet(u.ctx, sessionKeyPrefix+sessionID, "user_id").Result()
 	if err != nil {
 		return "", err
 	}
 	return userID, nil
 }
+
+// TouchSession updates the last-seen time of the session and returns the session record.
+// It returns redis.Nil if the session does not exist or was revoked.
+func (u *UserRepository) TouchSession(sessionID string) (*models.Session, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	res, err := touchSessionScript.Run(u.ctx, u.redisSession, []string{sessionKeyPrefix + sessionID}, now).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		fields[res[i]] = res[i+1]
+	}
+	return parseSession(sessionID, fields)
+}
+
+// GetUserSessions returns all live sessions of the user.
+// Sessions that already expired are dropped from the index along the way.
+func (u *UserRepository) GetUserSessions(userID string) ([]models.Session, error) {
+	sessionIDs, err := u.redisSession.SMembers(u.ctx, userSessionsKeyPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := u.redisSession.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		cmds[i] = pipe.HGetAll(u.ctx, sessionKeyPrefix+sessionID)
+	}
+	if _, err := pipe.Exec(u.ctx); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0, len(sessionIDs))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			u.redisSession.SRem(u.ctx, userSessionsKeyPrefix+userID, sessionIDs[i])
+			continue
+		}
+
+		session, err := parseSession(sessionIDs[i], fields)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+// DeleteSession removes a single session and drops it from the owner's session index.
+func (u *UserRepository) DeleteSession(sessionID string) error {
+	userID, err := u.GetUserIdBySession(sessionID)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return err
+	}
+
+	pipe := u.redisSession.TxPipeline()
+	pipe.Del(u.ctx, sessionKeyPrefix+sessionID)
+	pipe.SRem(u.ctx, userSessionsKeyPrefix+userID, sessionID)
+	_, err = pipe.Exec(u.ctx)
+	return err
+}
+
+// DeleteUserSessions removes every session of the user except exceptSessionID.
+// Pass an empty exceptSessionID to remove all of them.
+func (u *UserRepository) DeleteUserSessions(userID, exceptSessionID string) error {
+	sessionIDs, err := u.redisSession.SMembers(u.ctx, userSessionsKeyPrefix+userID).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := u.redisSession.TxPipeline()
+	for _, sessionID := range sessionIDs {
+		if sessionID == exceptSessionID {
+			continue
+		}
+		pipe.Del(u.ctx, sessionKeyPrefix+sessionID)
+		pipe.SRem(u.ctx, userSessionsKeyPrefix+userID, sessionID)
+	}
+	_, err = pipe.Exec(u.ctx)
+	return err
+}
+
+// parseSession builds a session record from the fields of its Redis hash.
+func parseSession(sessionID string, fields map[string]string) (*models.Session, error) {
+	userID, err := uuid.Parse(fields["user_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	lastSeen, _ := strconv.ParseInt(fields["last_seen"], 10, 64)
+	expiresAt, err := strconv.ParseInt(fields["expires_at"], 10, 64)
+	if err != nil {
+		// Sessions created before lifetimes were tracked end after the old fixed TTL.
+		expiresAt = createdAt + int64(SessionMaxLifetime.Seconds())
+	}
+
+	return &models.Session{
+		ID:         utils.HashToken(sessionID),
+		SessionID:  sessionID,
+		UserID:     userID,
+		CreatedAt:  time.Unix(createdAt, 0),
+		LastSeen:   time.Unix(lastSeen, 0),
+		ExpiresAt:  time.Unix(expiresAt, 0),
+		RememberMe: fields["remember"] == "1",
+		IP:         fields["ip"],
+		UserAgent:  fields["user_agent"],
+	}, nil
+}
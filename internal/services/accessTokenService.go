@@ -0,0 +1,126 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"blog/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTokenNotFound  = errors.New("token not found")
+	ErrInvalidToken   = errors.New("invalid token request")
+	ErrUnknownScope   = errors.New("unknown scope")
+	ErrTokenNameEmpty = errors.New("token name is required")
+)
+
+const (
+	// AccessTokenPrefix makes personal access tokens easy to recognize, e.g. by secret scanners.
+	AccessTokenPrefix = "blog_pat_"
+
+	defaultTokenLifetimeDays = 30
+	maxTokenLifetimeDays     = 365
+)
+
+type AccessTokenService struct {
+	AccessTokenRepository *repository.AccessTokenRepository
+}
+
+func NewAccessTokenService(accessTokenRepository *repository.AccessTokenRepository) *AccessTokenService {
+	return &AccessTokenService{AccessTokenRepository: accessTokenRepository}
+}
+
+// This method creates a personal access token for the user.
+// The scopes must be known, and the token expires after expiresInDays (30 by default, at most 365).
+// It returns the plain token, which is shown only once, together with the stored token record.
+func (a *AccessTokenService) CreateToken(userID uuid.UUID, name string, scopes []string, expiresInDays int) (string, *models.AccessToken, error) {
+
+	if name == "" {
+		return "", nil, ErrTokenNameEmpty
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, ErrUnknownScope
+	}
+	for _, scope := range scopes {
+		if !models.Scopes(models.AllScopes).Has(scope) {
+			log.Printf("Unknown scope %s requested by user %s", scope, userID.String())
+			return "", nil, fmt.Errorf("%w %s", ErrUnknownScope, scope)
+		}
+	}
+
+	if expiresInDays == 0 {
+		expiresInDays = defaultTokenLifetimeDays
+	}
+	if expiresInDays < 0 || expiresInDays > maxTokenLifetimeDays {
+		return "", nil, fmt.Errorf("%w: expires_in_days must be between 1 and %d", ErrInvalidToken, maxTokenLifetimeDays)
+	}
+
+	secret, err := utils.GenerateToken()
+	if err != nil {
+		log.Printf("Failed to generate token for user %s: %v", userID.String(), err)
+		return "", nil, errors.New("failed to generate token " + err.Error())
+	}
+	plain := AccessTokenPrefix + secret
+
+	token := &models.AccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: utils.HashToken(plain),
+		Scopes:    scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, expiresInDays),
+	}
+
+	if err := a.AccessTokenRepository.CreateToken(token); err != nil {
+		log.Printf("Failed to create token for user %s: %v", userID.String(), err)
+		return "", nil, errors.New("failed to create token " + err.Error())
+	}
+
+	log.Printf("Successfully created token %d for user %s", token.ID, userID.String())
+	return plain, token, nil
+}
+
+// This method returns a page of the personal access tokens of the user, newest first.
+func (a *AccessTokenService) GetTokens(userID uuid.UUID, page repository.Page) (*models.Page[models.AccessToken], error) {
+
+	page = pageLimit(page)
+	tokens, err := a.AccessTokenRepository.GetTokensByUser(userID, page)
+	if err != nil {
+		log.Printf("Failed to get tokens for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to get tokens " + err.Error())
+	}
+
+	log.Printf("Successfully retrieved tokens for user %s", userID.String())
+	return newPage(tokens, page.Limit, func(token models.AccessToken) repository.Cursor {
+		return repository.Cursor{Time: token.CreatedAt, ID: token.ID}
+	}), nil
+}
+
+// This method revokes a personal access token of the user.
+// It returns ErrTokenNotFound if the user has no such token.
+func (a *AccessTokenService) DeleteToken(tokenIDstr string, userID uuid.UUID) error {
+
+	tokenID, err := strconv.ParseUint(tokenIDstr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid token ID %s: %v", tokenIDstr, err)
+		return ErrTokenNotFound
+	}
+
+	deleted, err := a.AccessTokenRepository.DeleteToken(uint(tokenID), userID)
+	if err != nil {
+		log.Printf("Failed to delete token %s for user %s: %v", tokenIDstr, userID.String(), err)
+		return errors.New("failed to delete token " + err.Error())
+	}
+	if !deleted {
+		return ErrTokenNotFound
+	}
+
+	log.Printf("Successfully deleted token %s for user %s", tokenIDstr, userID.String())
+	return nil
+}
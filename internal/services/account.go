@@ -0,0 +1,78 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/utils"
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// This method collects all data stored about the user: the profile, every post and comment
+// (including hidden ones) and the active sessions.
+func (u *UserService) ExportAccount(userID uuid.UUID) (*models.AccountExport, error) {
+
+	profile, err := u.GetOwnProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, comments, err := u.UserRepository.GetUserContent(userID)
+	if err != nil {
+		log.Printf("Failed to get content of user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to get content " + err.Error())
+	}
+
+	sessions, err := u.UserRepository.GetUserSessions(userID.String())
+	if err != nil {
+		log.Printf("Failed to get sessions for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to get sessions " + err.Error())
+	}
+
+	log.Printf("Account of user %s exported successfully", userID.String())
+	return &models.AccountExport{Profile: *profile, Posts: posts, Comments: comments, Sessions: sessions}, nil
+}
+
+// This method deletes the account of the user after confirming their password.
+// The user, their posts, comments and tokens are removed from the database in one transaction,
+// which also clears the email, IP and user agent of their security events.
+// Afterwards all sessions and pending codes are removed from Redis, the avatar files are deleted
+// and a confirmation is sent to the email address of the account.
+func (u *UserService) DeleteAccount(userID uuid.UUID, password string) error {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return userLookupError(userID.String(), err)
+	}
+
+	if err := u.Passwords.Verify(password, user.Password); err != nil {
+		log.Printf("Wrong password for account deletion of user %s", user.Email)
+		return ErrWrongPassword
+	}
+
+	if err := u.UserRepository.DeleteUser(userID, user.Email); err != nil {
+		log.Printf("Failed to delete user %s: %v", user.Email, err)
+		return errors.New("failed to delete user " + err.Error())
+	}
+
+	// The security log keeps the events of the account, including its deletion, but no personal data.
+	u.recordEvent(models.EventAccountDeleted, userID, "", models.SessionMeta{}, "")
+
+	// The account is already gone at this point, so failures below are only logged.
+	if err := u.UserRepository.DeleteUserSessions(userID.String(), ""); err != nil {
+		log.Printf("Failed to revoke sessions for deleted user %s: %v", userID.String(), err)
+	}
+	if err := u.UserRepository.DeleteUserCodes(userID.String(), user.Email); err != nil {
+		log.Printf("Failed to delete codes for deleted user %s: %v", userID.String(), err)
+	}
+	deleteAvatarFiles(u.Storage, user.AvatarKey)
+
+	body := "Your account and all of your posts and comments have been deleted. We are sorry to see you go."
+	if err := utils.SendMail(user.Email, "Account deleted", body); err != nil {
+		log.Printf("Error while sending account deletion email to %s: %v", user.Email, err)
+	}
+
+	log.Printf("Account of user %s deleted successfully", user.Email)
+	return nil
+}
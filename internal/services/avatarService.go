@@ -0,0 +1,160 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"blog/storage"
+	"blog/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnsupportedImage = errors.New("avatar must be a JPEG, PNG or GIF image")
+	ErrImageTooLarge    = errors.New("avatar image dimensions are too large")
+)
+
+const (
+	// MaxAvatarUploadSize is the maximum size of an uploaded avatar file in bytes.
+	MaxAvatarUploadSize = 5 << 20
+	// maxAvatarPixels limits the decoded image size, so small files cannot expand into huge bitmaps.
+	maxAvatarPixels = 25_000_000
+
+	avatarSize      = 256
+	avatarThumbSize = 64
+)
+
+var allowedAvatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type AvatarService struct {
+	UserRepository *repository.UserRepository
+	Storage        storage.Storage
+}
+
+func NewAvatarService(userRepository *repository.UserRepository, storage storage.Storage) *AvatarService {
+	return &AvatarService{UserRepository: userRepository, Storage: storage}
+}
+
+// This method replaces the avatar of the user.
+// The image type is detected from its content, not from the client's Content-Type. The image is decoded,
+// cropped to a square and re-encoded as PNG in two sizes, which also strips EXIF and other metadata.
+// The previous avatar files are deleted once the new ones are stored.
+func (a *AvatarService) UpdateAvatar(userID uuid.UUID, data []byte) (*models.User, error) {
+
+	if !allowedAvatarTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Failed to read avatar header for user %s: %v", userID.String(), err)
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > maxAvatarPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Failed to decode avatar for user %s: %v", userID.String(), err)
+		return nil, ErrUnsupportedImage
+	}
+
+	user, err := a.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, userLookupError(userID.String(), err)
+	}
+
+	suffix, err := utils.GenerateSessionID()
+	if err != nil {
+		log.Printf("Failed to generate avatar key for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to generate avatar key " + err.Error())
+	}
+	key := fmt.Sprintf("avatars/%s/%s", userID.String(), suffix)
+
+	ctx := context.Background()
+	urls := make(map[int]string, 2)
+	for _, size := range []int{avatarSize, avatarThumbSize} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, utils.SquareThumbnail(img, size)); err != nil {
+			log.Printf("Failed to encode avatar for user %s: %v", userID.String(), err)
+			return nil, errors.New("failed to encode avatar " + err.Error())
+		}
+
+		url, err := a.Storage.Put(ctx, avatarFileKey(key, size), buf.Bytes(), "image/png")
+		if err != nil {
+			log.Printf("Failed to store avatar for user %s: %v", userID.String(), err)
+			return nil, errors.New("failed to store avatar " + err.Error())
+		}
+		urls[size] = url
+	}
+
+	fields := map[string]interface{}{
+		"avatar_url":       urls[avatarSize],
+		"avatar_thumb_url": urls[avatarThumbSize],
+		"avatar_key":       key,
+	}
+	if err := a.UserRepository.UpdateProfile(userID, fields); err != nil {
+		log.Printf("Failed to update avatar of user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to update avatar " + err.Error())
+	}
+
+	deleteAvatarFiles(a.Storage, user.AvatarKey)
+
+	user.AvatarURL = urls[avatarSize]
+	user.AvatarThumbURL = urls[avatarThumbSize]
+	user.AvatarKey = key
+
+	log.Printf("Avatar of user %s updated successfully", userID.String())
+	return user, nil
+}
+
+// This method removes the avatar of the user and deletes its files.
+func (a *AvatarService) DeleteAvatar(userID uuid.UUID) error {
+
+	user, err := a.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return userLookupError(userID.String(), err)
+	}
+
+	fields := map[string]interface{}{"avatar_url": "", "avatar_thumb_url": "", "avatar_key": ""}
+	if err := a.UserRepository.UpdateProfile(userID, fields); err != nil {
+		log.Printf("Failed to delete avatar of user %s: %v", userID.String(), err)
+		return errors.New("failed to delete avatar " + err.Error())
+	}
+
+	deleteAvatarFiles(a.Storage, user.AvatarKey)
+
+	log.Printf("Avatar of user %s deleted successfully", userID.String())
+	return nil
+}
+
+// deleteAvatarFiles removes all sizes of an avatar. Failures are only logged, the files are just left behind.
+func deleteAvatarFiles(store storage.Storage, key string) {
+	if key == "" {
+		return
+	}
+	for _, size := range []int{avatarSize, avatarThumbSize} {
+		if err := store.Delete(context.Background(), avatarFileKey(key, size)); err != nil {
+			log.Printf("Failed to delete avatar file %s: %v", avatarFileKey(key, size), err)
+		}
+	}
+}
+
+func avatarFileKey(key string, size int) string {
+	return fmt.Sprintf("%s-%d.png", key, size)
+}
@@ -6,31 +6,40 @@ import (
 	"errors"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+var ErrCommentNotFound = errors.New("comment not found")
+
 type CommentServices struct {
 	CommentRepository *repository.CommentRepository
+	PostRepository    *repository.PostRepository
 }
 
-func NewCommentService(commentRepository *repository.CommentRepository) *CommentServices {
-	return &CommentServices{CommentRepository: commentRepository}
+func NewCommentService(commentRepository *repository.CommentRepository, postRepository *repository.PostRepository) *CommentServices {
+	return &CommentServices{CommentRepository: commentRepository, PostRepository: postRepository}
 }
 
 // This method creates a new comment for the specified post.
 // It sets the user and post IDs, and then saves the comment to the repository.
-// It returns an error if the post ID is invalid or if the comment creation fails.
+// It returns ErrPostNotFound if the post does not exist or the user may not see it,
+// or an error if the comment creation fails.
 func (c *CommentServices) CreateComment(comment *models.Comment, userID uuid.UUID, postIDstr string) error {
 
-	comment.UserID = userID
-
-	postID, err := strconv.ParseUint(postIDstr, 10, 32)
+	post, err := c.visiblePost(postIDstr, userID)
 	if err != nil {
-		log.Printf("Invalid post ID %s: %v", postIDstr, err)
-		return errors.New("invalid post ID" + err.Error())
+		return err
 	}
-	comment.PostId = uint(postID)
+
+	// The ID and timestamps are assigned by the database; comment lists and cursors are ordered by them.
+	comment.ID = 0
+	comment.CreatedAt, comment.UpdatedAt = time.Time{}, time.Time{}
+	comment.Hidden = false
+	comment.UserID = userID
+	comment.PostId = post.ID
 
 	if err := c.CommentRepository.CreateComment(comment); err != nil {
 		log.Printf("Failed to create comment for post %s: %v", postIDstr, err)
@@ -41,25 +50,28 @@ func (c *CommentServices) CreateComment(comment *models.Comment, userID uuid.UUI
 	return nil
 }
 
-// This method retrieves all comments for the specified post.
-// It converts the post's string ID to a number and fetches the comments associated with it.
-// It returns an error if the post ID is invalid or if fetching comments fails.
-func (c *CommentServices) GetComments(postIDstr string) ([]models.Comment, error) {
+// This method retrieves a page of the comments for the specified post.
+// It loads the post and fetches the comments associated with it; viewerID is uuid.Nil for anonymous viewers.
+// It returns ErrPostNotFound if the post does not exist or the viewer may not see it,
+// or an error if fetching comments fails.
+func (c *CommentServices) GetComments(postIDstr string, viewerID uuid.UUID, page repository.Page) (*models.Page[models.Comment], error) {
 
-	postID, err := strconv.ParseUint(postIDstr, 10, 32)
+	post, err := c.visiblePost(postIDstr, viewerID)
 	if err != nil {
-		log.Printf("Invalid post ID %s: %v", postIDstr, err)
-		return nil, errors.New("invalid post ID" + err.Error())
+		return nil, err
 	}
 
-	comments, err := c.CommentRepository.GetCommentsByPostId(uint(postID))
+	page = pageLimit(page)
+	comments, err := c.CommentRepository.GetCommentsByPostId(post.ID, page)
 	if err != nil {
 		log.Printf("Failed to get comments for post %s: %v", postIDstr, err)
 		return nil, errors.New("failed to get comments" + err.Error())
 	}
 
 	log.Printf("Successfully retrieved comments for post %s", postIDstr)
-	return comments, nil
+	return newPage(comments, page.Limit, func(comment models.Comment) repository.Cursor {
+		return repository.Cursor{Time: comment.CreatedAt, ID: comment.ID}
+	}), nil
 }
 
 // This method deletes a comment with the specified ID for the given post.
@@ -87,3 +99,28 @@ func (c *CommentServices) DeleteComment(commentIDstr string, postIDstr string, u
 	log.Printf("Successfully deleted comment %s for post %s by user %s", commentIDstr, postIDstr, userID.String())
 	return nil
 }
+
+// visiblePost loads the post with the given ID, returning ErrPostNotFound if it does not exist
+// or is not visible to the viewer, so comments of hidden, draft, scheduled and private posts stay out of reach.
+func (c *CommentServices) visiblePost(postIDstr string, viewerID uuid.UUID) (*models.Post, error) {
+
+	postID, err := strconv.ParseUint(postIDstr, 10, 32)
+	if err != nil {
+		log.Printf("Invalid post ID %s: %v", postIDstr, err)
+		return nil, ErrPostNotFound
+	}
+
+	post, err := c.PostRepository.GetPost(uint(postID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		log.Printf("Failed to get post %s: %v", postIDstr, err)
+		return nil, errors.New("failed to get post " + err.Error())
+	}
+
+	if !post.VisibleTo(viewerID, time.Now()) {
+		return nil, ErrPostNotFound
+	}
+	return post, nil
+}
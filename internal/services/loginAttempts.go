@@ -0,0 +1,141 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Number of failed attempts after which an email or an IP address is locked out.
+	maxEmailLoginFailures = 5
+	maxIPLoginFailures    = 20
+
+	// The first lockout lasts baseLockout, every further failure doubles it up to maxLockout.
+	baseLockout = time.Minute
+	maxLockout  = time.Hour
+)
+
+// LockoutError is returned by LoginUser while logins for the email or the client IP are blocked.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// checkLockout returns a LockoutError if logins for the email or the IP address are currently blocked.
+func (u *UserService) checkLockout(email, ip string) error {
+	for _, key := range []string{"email:" + email, "ip:" + ip} {
+		retryAfter, err := u.LoginAttempts.GetLockout(key)
+		if err != nil {
+			log.Printf("Error while checking lockout for %s: %v", key, err)
+			return errors.New("error while checking lockout " + err.Error())
+		}
+		if retryAfter > 0 {
+			log.Printf("Login blocked for %s for %s", key, retryAfter)
+			return &LockoutError{RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// loginFailed records a failed login for the email and the IP address and locks them out once they exceed their limits.
+// The owner of the account is notified by email when their account gets locked.
+// It returns a LockoutError if a lockout was triggered and ErrInvalidCredentials otherwise.
+// Every failure and lockout is recorded in the security log.
+func (u *UserService) loginFailed(email string, meta models.SessionMeta, user *models.User) error {
+	userID := uuid.Nil
+	if user != nil {
+		userID = user.ID
+	}
+	u.recordEvent(models.EventLoginFailed, userID, email, meta, "")
+
+	return u.registerLoginFailure(email, meta, user)
+}
+
+// registerLoginFailure counts a failed password or second-factor attempt for the email and the IP address
+// and locks them out once they exceed their limits, without recording the failure itself in the security log.
+// It returns a LockoutError if a lockout was triggered and ErrInvalidCredentials otherwise.
+func (u *UserService) registerLoginFailure(email string, meta models.SessionMeta, user *models.User) error {
+	var lockout time.Duration
+
+	userID := uuid.Nil
+	if user != nil {
+		userID = user.ID
+	}
+
+	failures := []struct {
+		key   string
+		limit int64
+	}{
+		{key: "email:" + email, limit: maxEmailLoginFailures},
+		{key: "ip:" + meta.IP, limit: maxIPLoginFailures},
+	}
+
+	for _, f := range failures {
+		count, err := u.LoginAttempts.RegisterFailure(f.key)
+		if err != nil {
+			log.Printf("Error while registering failed login for %s: %v", f.key, err)
+			return errors.New("error while registering failed login " + err.Error())
+		}
+		if count < f.limit {
+			continue
+		}
+
+		duration := lockoutDuration(count - f.limit)
+		if err := u.LoginAttempts.Lock(f.key, duration); err != nil {
+			log.Printf("Error while locking %s: %v", f.key, err)
+			return errors.New("error while locking login " + err.Error())
+		}
+		log.Printf("Login locked for %s for %s after %d failed attempts", f.key, duration, count)
+		u.recordEvent(models.EventLockout, userID, email, meta, fmt.Sprintf("%s locked for %s after %d failed attempts", f.key, duration, count))
+
+		if duration > lockout {
+			lockout = duration
+		}
+		if f.key == "email:"+email && count == f.limit && user != nil {
+			go notifyLockout(user.Email, meta.IP, duration)
+		}
+	}
+
+	if lockout > 0 {
+		return &LockoutError{RetryAfter: lockout}
+	}
+	return ErrInvalidCredentials
+}
+
+// resetLoginFailures clears the failure counter of the email after a completed login.
+func (u *UserService) resetLoginFailures(email string) {
+	if err := u.LoginAttempts.Reset("email:" + email); err != nil {
+		log.Printf("Error while resetting failed logins for user %s: %v", email, err)
+	}
+}
+
+// lockoutDuration doubles the lockout for every failure past the limit.
+func lockoutDuration(extraFailures int64) time.Duration {
+	if extraFailures > 10 {
+		return maxLockout
+	}
+	duration := baseLockout << extraFailures
+	if duration > maxLockout {
+		return maxLockout
+	}
+	return duration
+}
+
+// notifyLockout tells the account owner that their account was temporarily locked.
+func notifyLockout(email, ip string, duration time.Duration) {
+	body := fmt.Sprintf("We blocked logins to your account for %s after several failed password attempts from IP %s.\n\n"+
+		"If this was not you, consider resetting your password.", duration, ip)
+	if err := utils.SendMail(email, "Your account was temporarily locked", body); err != nil {
+		log.Printf("Error while sending lockout notification to %s: %v", email, err)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/utils"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMagicLinkDisabled = errors.New("login links are not enabled")
+	ErrInvalidMagicLink  = errors.New("invalid or expired login link, request a new one")
+)
+
+// magicLinkSecret returns the key login links are signed with. Login links are disabled without it.
+func magicLinkSecret() (string, error) {
+	secret := os.Getenv("MAGIC_LINK_SECRET")
+	if secret == "" {
+		return "", ErrMagicLinkDisabled
+	}
+	return secret, nil
+}
+
+// This method emails a single-use login link to the user with the given email.
+// Like ForgotPassword, it does not report whether the account exists, so the caller must answer the same way in both cases.
+// Links to the same email are sent at most once per minute; further requests are silently ignored.
+func (u *UserService) RequestMagicLink(email string) error {
+
+	secret, err := magicLinkSecret()
+	if err != nil {
+		return err
+	}
+
+	user, err := u.UserRepository.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Login link requested for unknown email %s: %v", email, err)
+		return nil
+	}
+
+	fresh, err := u.UserRepository.StartMagicLinkCooldown(user.Email)
+	if err != nil {
+		log.Printf("Failed to start login link cooldown for user %s: %v", email, err)
+		return errors.New("failed to start login link cooldown " + err.Error())
+	}
+	if !fresh {
+		log.Printf("Login link for user %s requested again too soon", email)
+		return nil
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		log.Printf("Failed to generate login link for user %s: %v", email, err)
+		return errors.New("failed to generate login link " + err.Error())
+	}
+
+	if err := u.UserRepository.CreateMagicLink(utils.HashToken(token), user.ID.String()); err != nil {
+		log.Printf("Failed to store login link for user %s: %v", email, err)
+		return errors.New("failed to store login link " + err.Error())
+	}
+
+	// The email is sent in the background so the response time does not reveal whether the account exists.
+	link := fmt.Sprintf("%s/login/magic/%s", os.Getenv("APP_BASE_URL"), utils.SignToken(token, secret))
+	go func() {
+		body := fmt.Sprintf("To log in, follow the link below. It is valid for 15 minutes and can be used once.\n\n%s\n\nIf you did not request this link, ignore this email.", link)
+		if err := utils.SendMail(user.Email, "Your login link", body); err != nil {
+			log.Printf("Error while sending login link to %s: %v", user.Email, err)
+		}
+	}()
+
+	log.Printf("Login link created for user %s", email)
+	return nil
+}
+
+// This method logs the user in with a login link sent by RequestMagicLink. The link is consumed on first use.
+// Since the link was delivered to the user's mailbox, it also confirms their email address.
+// If the user has two-factor authentication enabled, a challenge token is returned instead of a session, as in LoginUser.
+// It returns ErrInvalidMagicLink if the link is forged, unknown, expired or was already used.
+func (u *UserService) LoginWithMagicLink(signedToken string, meta models.SessionMeta) (*LoginResult, error) {
+
+	secret, err := magicLinkSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := utils.VerifySignedToken(signedToken, secret)
+	if !ok {
+		log.Printf("Login link with an invalid signature")
+		return nil, ErrInvalidMagicLink
+	}
+
+	userIDStr, err := u.UserRepository.ConsumeMagicLink(utils.HashToken(token))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			log.Printf("Invalid or expired login link")
+			return nil, ErrInvalidMagicLink
+		}
+		log.Printf("Failed to get login link: %v", err)
+		return nil, errors.New("failed to get login link " + err.Error())
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		log.Printf("Invalid user ID %s in login link: %v", userIDStr, err)
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		// The account may have been deleted after the link was sent.
+		if err := userLookupError(userIDStr, err); !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, ErrInvalidMagicLink
+	}
+
+	if !user.IsVerified {
+		if err := u.UserRepository.MarkVerified(user.ID); err != nil {
+			log.Printf("Failed to verify email of user %s: %v", user.Email, err)
+			return nil, errors.New("failed to verify email " + err.Error())
+		}
+		user.IsVerified = true
+		u.recordEvent(models.EventEmailVerified, user.ID, user.Email, meta, "via login link")
+	}
+
+	if user.TOTPEnabled {
+		return u.createLoginChallenge(user, meta.RememberMe)
+	}
+
+	session, err := u.createSession(user, meta, "login link")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s logged in successfully with a login link", user.Email)
+	return &LoginResult{User: user, IssuedSession: *session}, nil
+}
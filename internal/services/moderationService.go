@@ -0,0 +1,190 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ModerationService struct {
+	ModerationRepository *repository.ModerationRepository
+}
+
+func NewModerationService(moderationRepository *repository.ModerationRepository) *ModerationService {
+	return &ModerationService{ModerationRepository: moderationRepository}
+}
+
+// This method hides or unhides any post on behalf of a moderator.
+// Hidden posts are not shown in listings. The action is recorded with the given reason.
+// It returns ErrPostNotFound if there is no such post.
+func (m *ModerationService) SetPostHidden(postIDstr string, moderatorID uuid.UUID, hidden bool, reason string) error {
+
+	post, err := m.getPost(postIDstr)
+	if err != nil {
+		return err
+	}
+
+	action := &models.ModerationAction{
+		ModeratorID:  moderatorID,
+		Action:       models.ModerationUnhide,
+		TargetType:   models.TargetPost,
+		TargetID:     post.ID,
+		TargetUserID: post.UserID,
+		Reason:       reason,
+	}
+	if hidden {
+		action.Action = models.ModerationHide
+	}
+
+	if err := m.ModerationRepository.SetPostHidden(post.ID, hidden, action); err != nil {
+		log.Printf("Failed to %s post %s by moderator %s: %v", action.Action, postIDstr, moderatorID.String(), err)
+		return errors.New("failed to moderate post " + err.Error())
+	}
+
+	log.Printf("Moderator %s applied %s to post %s", moderatorID.String(), action.Action, postIDstr)
+	return nil
+}
+
+// This method deletes any post together with its comments on behalf of a moderator.
+// The action is recorded with the given reason. It returns ErrPostNotFound if there is no such post.
+func (m *ModerationService) DeletePost(postIDstr string, moderatorID uuid.UUID, reason string) error {
+
+	post, err := m.getPost(postIDstr)
+	if err != nil {
+		return err
+	}
+
+	action := &models.ModerationAction{
+		ModeratorID:  moderatorID,
+		Action:       models.ModerationDelete,
+		TargetType:   models.TargetPost,
+		TargetID:     post.ID,
+		TargetUserID: post.UserID,
+		Reason:       reason,
+	}
+
+	if err := m.ModerationRepository.DeletePost(post.ID, action); err != nil {
+		log.Printf("Failed to delete post %s by moderator %s: %v", postIDstr, moderatorID.String(), err)
+		return errors.New("failed to delete post " + err.Error())
+	}
+
+	log.Printf("Moderator %s deleted post %s", moderatorID.String(), postIDstr)
+	return nil
+}
+
+// This method hides or unhides any comment on behalf of a moderator.
+// The action is recorded with the given reason. It returns ErrCommentNotFound if there is no such comment.
+func (m *ModerationService) SetCommentHidden(commentIDstr string, moderatorID uuid.UUID, hidden bool, reason string) error {
+
+	comment, err := m.getComment(commentIDstr)
+	if err != nil {
+		return err
+	}
+
+	action := &models.ModerationAction{
+		ModeratorID:  moderatorID,
+		Action:       models.ModerationUnhide,
+		TargetType:   models.TargetComment,
+		TargetID:     comment.ID,
+		TargetUserID: comment.UserID,
+		Reason:       reason,
+	}
+	if hidden {
+		action.Action = models.ModerationHide
+	}
+
+	if err := m.ModerationRepository.SetCommentHidden(comment.ID, hidden, action); err != nil {
+		log.Printf("Failed to %s comment %s by moderator %s: %v", action.Action, commentIDstr, moderatorID.String(), err)
+		return errors.New("failed to moderate comment " + err.Error())
+	}
+
+	log.Printf("Moderator %s applied %s to comment %s", moderatorID.String(), action.Action, commentIDstr)
+	return nil
+}
+
+// This method deletes any comment on behalf of a moderator.
+// The action is recorded with the given reason. It returns ErrCommentNotFound if there is no such comment.
+func (m *ModerationService) DeleteComment(commentIDstr string, moderatorID uuid.UUID, reason string) error {
+
+	comment, err := m.getComment(commentIDstr)
+	if err != nil {
+		return err
+	}
+
+	action := &models.ModerationAction{
+		ModeratorID:  moderatorID,
+		Action:       models.ModerationDelete,
+		TargetType:   models.TargetComment,
+		TargetID:     comment.ID,
+		TargetUserID: comment.UserID,
+		Reason:       reason,
+	}
+
+	if err := m.ModerationRepository.DeleteComment(comment.ID, action); err != nil {
+		log.Printf("Failed to delete comment %s by moderator %s: %v", commentIDstr, moderatorID.String(), err)
+		return errors.New("failed to delete comment " + err.Error())
+	}
+
+	log.Printf("Moderator %s deleted comment %s", moderatorID.String(), commentIDstr)
+	return nil
+}
+
+// This method returns the most recent moderation actions.
+func (m *ModerationService) GetActions(page repository.Page) (*models.Page[models.ModerationAction], error) {
+
+	page = pageLimit(page)
+	actions, err := m.ModerationRepository.GetActions(page)
+	if err != nil {
+		log.Printf("Failed to get moderation actions: %v", err)
+		return nil, errors.New("failed to get moderation actions " + err.Error())
+	}
+
+	return newPage(actions, page.Limit, func(action models.ModerationAction) repository.Cursor {
+		return repository.Cursor{Time: action.CreatedAt, ID: action.ID}
+	}), nil
+}
+
+// getPost parses the post ID and loads the post, returning ErrPostNotFound if it does not exist.
+func (m *ModerationService) getPost(postIDstr string) (*models.Post, error) {
+
+	postID, err := strconv.ParseUint(postIDstr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid post ID %s: %v", postIDstr, err)
+		return nil, ErrPostNotFound
+	}
+
+	post, err := m.ModerationRepository.GetPost(uint(postID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		log.Printf("Failed to get post %s: %v", postIDstr, err)
+		return nil, errors.New("failed to get post " + err.Error())
+	}
+	return post, nil
+}
+
+// getComment parses the comment ID and loads the comment, returning ErrCommentNotFound if it does not exist.
+func (m *ModerationService) getComment(commentIDstr string) (*models.Comment, error) {
+
+	commentID, err := strconv.ParseUint(commentIDstr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid comment ID %s: %v", commentIDstr, err)
+		return nil, ErrCommentNotFound
+	}
+
+	comment, err := m.ModerationRepository.GetComment(uint(commentID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		log.Printf("Failed to get comment %s: %v", commentIDstr, err)
+		return nil, errors.New("failed to get comment " + err.Error())
+	}
+	return comment, nil
+}
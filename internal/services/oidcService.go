@@ -0,0 +1,200 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/oidc"
+	"blog/utils"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidOIDCState      = errors.New("invalid or expired login attempt, start the login again")
+	ErrOIDCEmailNotVerified  = errors.New("the provider did not confirm your email address")
+	ErrOIDCAccountUnverified = errors.New("an unverified account with this email exists, verify it or reset its password first")
+	ErrOIDCProvider          = errors.New("login with the external provider failed")
+)
+
+// OIDCService signs users in with an external OpenID Connect provider.
+// Sessions are created the same way as for a password login, so it uses the UserService for them.
+type OIDCService struct {
+	Users    *UserService
+	Provider *oidc.Provider
+}
+
+func NewOIDCService(users *UserService, provider *oidc.Provider) *OIDCService {
+	return &OIDCService{Users: users, Provider: provider}
+}
+
+// This method starts a login with the external provider and returns the URL the user is redirected to.
+// The state, nonce and PKCE verifier are kept in Redis until the provider redirects back.
+// It also returns the browser binding, the hash of the state, which the client keeps in a cookie
+// and sends back to CompleteLogin, so the login can only be completed in the browser that started it.
+func (o *OIDCService) StartLogin() (string, string, error) {
+
+	var values [3]string
+	for i := range values {
+		value, err := utils.GenerateToken()
+		if err != nil {
+			log.Printf("Failed to generate OIDC state: %v", err)
+			return "", "", errors.New("failed to generate OIDC state " + err.Error())
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	if err := o.Users.UserRepository.CreateOIDCState(state, verifier, nonce); err != nil {
+		log.Printf("Failed to store OIDC state: %v", err)
+		return "", "", errors.New("failed to store OIDC state " + err.Error())
+	}
+
+	authURL, err := o.Provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Failed to build OIDC authorization URL: %v", err)
+		return "", "", fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+
+	return authURL, utils.HashToken(state), nil
+}
+
+// This method completes a login when the provider redirects back with an authorization code.
+// The external account is looked up by its issuer and subject. If it is not linked yet, it is linked to the user
+// with the same verified email, or a new user marked as verified is created. Accounts with an unverified email
+// are never linked automatically, since whoever registered them may not own the address.
+// If the user has two-factor authentication enabled, a challenge token is returned instead of a session.
+// The binding must be the one StartLogin returned for the state; otherwise someone else's callback URL
+// could sign the user into a foreign account, and ErrInvalidOIDCState is returned.
+func (o *OIDCService) CompleteLogin(state, binding, code string, meta models.SessionMeta) (*LoginResult, error) {
+
+	if binding == "" || subtle.ConstantTimeCompare([]byte(utils.HashToken(state)), []byte(binding)) != 1 {
+		log.Printf("OIDC state does not belong to this browser")
+		return nil, ErrInvalidOIDCState
+	}
+
+	verifier, nonce, err := o.Users.UserRepository.ConsumeOIDCState(state)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			log.Printf("Unknown OIDC state")
+			return nil, ErrInvalidOIDCState
+		}
+		log.Printf("Failed to get OIDC state: %v", err)
+		return nil, errors.New("failed to get OIDC state " + err.Error())
+	}
+
+	claims, err := o.Provider.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+
+	user, err := o.findOrCreateUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return o.Users.createLoginChallenge(user, meta.RememberMe)
+	}
+
+	session, err := o.Users.createSession(user, meta, "oidc "+claims.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s logged in successfully with %s", user.Email, claims.Issuer)
+	return &LoginResult{User: user, IssuedSession: *session}, nil
+}
+
+// findOrCreateUser returns the user linked to the external account, linking or creating one if needed.
+func (o *OIDCService) findOrCreateUser(claims *oidc.Claims) (*models.User, error) {
+	repo := o.Users.UserRepository
+
+	user, err := repo.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error while getting identity %s of %s: %v", claims.Subject, claims.Issuer, err)
+		return nil, errors.New("error while getting identity " + err.Error())
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		log.Printf("Identity %s of %s has no verified email", claims.Subject, claims.Issuer)
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	identity := &models.UserIdentity{Issuer: claims.Issuer, Subject: claims.Subject, Email: claims.Email}
+
+	user, err = repo.GetUserByEmail(claims.Email)
+	if err == nil {
+		if !user.IsVerified {
+			log.Printf("Refusing to link identity of %s to unverified user %s", claims.Issuer, user.Email)
+			return nil, ErrOIDCAccountUnverified
+		}
+
+		identity.UserID = user.ID
+		if err := repo.CreateIdentity(identity); err != nil {
+			log.Printf("Failed to link identity of %s to user %s: %v", claims.Issuer, user.Email, err)
+			return nil, errors.New("failed to link identity " + err.Error())
+		}
+
+		log.Printf("Identity of %s linked to user %s", claims.Issuer, user.Email)
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error while getting user %s: %v", claims.Email, err)
+		return nil, errors.New("error while getting user " + err.Error())
+	}
+
+	// The user signs in through the provider and has no password of their own.
+	// They get a random one they never see, and can set a real one with the password reset.
+	randomPassword, err := utils.GenerateToken()
+	if err != nil {
+		log.Printf("Failed to generate password for user %s: %v", claims.Email, err)
+		return nil, errors.New("failed to generate password " + err.Error())
+	}
+	hashedPassword, err := o.Users.Passwords.Hash(randomPassword)
+	if err != nil {
+		log.Printf("Error while hashing password for user %s: %v", claims.Email, err)
+		return nil, errors.New("error while hashing password " + err.Error())
+	}
+
+	user = &models.User{
+		Username:    o.suggestUsername(claims.PreferredUsername),
+		Email:       claims.Email,
+		Password:    hashedPassword,
+		IsVerified:  true,
+		Role:        models.RoleUser,
+		DisplayName: claims.Name,
+	}
+	if err := repo.CreateUserWithIdentity(user, identity); err != nil {
+		log.Printf("Error while creating user %s: %v", claims.Email, err)
+		return nil, errors.New("error while creating user " + err.Error())
+	}
+
+	log.Printf("User %s registered successfully with %s", user.Email, claims.Issuer)
+	return user, nil
+}
+
+// suggestUsername uses the provider's preferred username as handle if it is valid and free.
+// Otherwise the user is created without a handle and can pick one later.
+func (o *OIDCService) suggestUsername(preferred string) string {
+	// Providers often use the email address as preferred username.
+	preferred, _, _ = strings.Cut(preferred, "@")
+
+	if validateUsername(preferred) != nil {
+		return ""
+	}
+	if err := o.Users.checkUsernameAvailable(preferred, uuid.Nil); err != nil {
+		return ""
+	}
+	return preferred
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageLimit returns the page with the default page size applied and the limit capped at maxPageSize.
+func pageLimit(page repository.Page) repository.Page {
+	if page.Limit <= 0 {
+		page.Limit = defaultPageSize
+	}
+	if page.Limit > maxPageSize {
+		page.Limit = maxPageSize
+	}
+	return page
+}
+
+// newPage builds a page from items fetched with one item more than the limit.
+// If the extra item is present, it is dropped and the cursor of the last item continues the list.
+func newPage[T any](items []T, limit int, cursor func(T) repository.Cursor) *models.Page[T] {
+	if len(items) <= limit {
+		if items == nil {
+			items = []T{}
+		}
+		return &models.Page[T]{Items: items}
+	}
+
+	items = items[:limit]
+	return &models.Page[T]{Items: items, NextCursor: cursor(items[limit-1]).String()}
+}
+
+// postCursor returns the position of the post in a list.
+func postCursor(post models.Post) repository.Cursor {
+	return repository.Cursor{Time: post.PublishedAt, ID: post.ID}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"blog/internal/models"
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+const (
+	minPasswordLength = 8
+	// maxPasswordLength keeps hashing cheap for absurdly long inputs.
+	maxPasswordLength = 128
+)
+
+//go:embed breached_passwords.txt
+var breachedPasswordList string
+
+// breachedPasswords is the lowercased set of passwords known from public breaches.
+var breachedPasswords = loadBreachedPasswords(breachedPasswordList)
+
+func loadBreachedPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+	return passwords
+}
+
+// rehashPassword upgrades the stored hash of the user after a successful login,
+// if it was made with another algorithm or weaker parameters than the ones configured now.
+// Failures are only logged, the old hash stays valid.
+func (u *UserService) rehashPassword(user *models.User, password string) {
+
+	if !u.Passwords.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := u.Passwords.Hash(password)
+	if err != nil {
+		log.Printf("Error while rehashing password for user %s: %v", user.Email, err)
+		return
+	}
+
+	if err := u.UserRepository.UpdatePassword(user.ID, hashedPassword); err != nil {
+		log.Printf("Failed to store rehashed password for user %s: %v", user.Email, err)
+		return
+	}
+
+	user.Password = hashedPassword
+	log.Printf("Password hash of user %s upgraded", user.Email)
+}
+
+// validatePassword checks a new password against the password policy.
+// Following NIST SP 800-63B, it checks the length and rejects known breached passwords
+// and passwords equal to the email or username, instead of demanding character classes.
+func validatePassword(password, email, username string) error {
+
+	if password == "" {
+		return ErrPasswordRequired
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < minPasswordLength || length > maxPasswordLength {
+		return fmt.Errorf("%w: it must be %d-%d characters long", ErrWeakPassword, minPasswordLength, maxPasswordLength)
+	}
+
+	lower := strings.ToLower(password)
+	if breachedPasswords[lower] {
+		return fmt.Errorf("%w: it appears in a list of breached passwords", ErrWeakPassword)
+	}
+	if lower == strings.ToLower(email) || (username != "" && lower == strings.ToLower(username)) {
+		return fmt.Errorf("%w: it must not be your email or username", ErrWeakPassword)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrInvalidRevision  = errors.New("revision must be a positive number")
+)
+
+// This method returns all revisions of a post, newest first.
+// Only the author and moderators can see the revisions of a post.
+// It returns ErrPostNotFound if there is no such post or the user may not see it, or ErrPostForbidden if the user may not see its revisions.
+func (p *PostService) GetRevisions(postIDStr string, userID uuid.UUID) ([]models.PostRevision, error) {
+
+	post, err := p.getPost(postIDStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.authorizeEdit(post, userID); err != nil {
+		return nil, err
+	}
+
+	return p.revisions(post)
+}
+
+// This method returns the differences between two revisions of a post as a unified diff of the title and content.
+// If to is empty, the latest revision is used; if from is empty, the revision before to is used.
+// It returns ErrInvalidRevision if a revision is not a number, ErrRevisionNotFound if there is no such revision,
+// or utils.ErrDiffTooLarge if the revisions are too large to compare.
+func (p *PostService) DiffRevisions(postIDStr string, userID uuid.UUID, fromStr, toStr string) (string, error) {
+
+	revisions, err := p.GetRevisions(postIDStr, userID)
+	if err != nil {
+		return "", err
+	}
+
+	to := revisions[0].Revision
+	if toStr != "" {
+		if to, err = parseRevision(toStr); err != nil {
+			return "", err
+		}
+	}
+	from := max(to-1, 1)
+	if fromStr != "" {
+		if from, err = parseRevision(fromStr); err != nil {
+			return "", err
+		}
+	}
+
+	fromRevision, err := findRevision(revisions, from)
+	if err != nil {
+		return "", err
+	}
+	toRevision, err := findRevision(revisions, to)
+	if err != nil {
+		return "", err
+	}
+
+	return utils.UnifiedDiff(
+		fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to),
+		revisionText(fromRevision), revisionText(toRevision),
+	)
+}
+
+// This method restores the title and content of a post from one of its revisions.
+// The restored version is stored as a new revision, so the history is kept.
+// It returns ErrRevisionNotFound if there is no such revision, and the same errors as UpdatePost otherwise.
+func (p *PostService) RestoreRevision(postIDStr, revisionStr string, userID uuid.UUID) (*models.Post, error) {
+
+	number, err := parseRevision(revisionStr)
+	if err != nil {
+		return nil, ErrRevisionNotFound
+	}
+
+	post, err := p.getPost(postIDStr)
+	if err != nil {
+		return nil, err
+	}
+
+	moderator, err := p.authorizeEdit(post, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := p.revisions(post)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := findRevision(revisions, number)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := *post
+	restored.Title = revision.Title
+	restored.Content = revision.Content
+
+	return p.savePost(post, &restored, userID, moderator, "restored revision "+revisionStr)
+}
+
+// revisions returns the revisions of the post, newest first.
+// Posts that were not edited since revisions are kept have only their current version as revision 1.
+func (p *PostService) revisions(post *models.Post) ([]models.PostRevision, error) {
+
+	revisions, err := p.PostRepository.GetRevisions(post.ID)
+	if err != nil {
+		log.Printf("Failed to get revisions of post %d: %v", post.ID, err)
+		return nil, errors.New("failed to get revisions " + err.Error())
+	}
+
+	if len(revisions) == 0 {
+		first := post.NewRevision(1, post.UserID)
+		first.CreatedAt = post.UpdatedAt
+		revisions = []models.PostRevision{*first}
+	}
+	return revisions, nil
+}
+
+// parseRevision parses a revision number, returning ErrInvalidRevision if it is not a positive number.
+func parseRevision(revisionStr string) (int, error) {
+	number, err := strconv.Atoi(revisionStr)
+	if err != nil || number < 1 {
+		return 0, ErrInvalidRevision
+	}
+	return number, nil
+}
+
+// findRevision returns the revision with the given number, or ErrRevisionNotFound.
+func findRevision(revisions []models.PostRevision, number int) (*models.PostRevision, error) {
+	for i := range revisions {
+		if revisions[i].Revision == number {
+			return &revisions[i], nil
+		}
+	}
+	return nil, ErrRevisionNotFound
+}
+
+// revisionText renders a revision as the text that is compared: the title, an empty line and the content.
+func revisionText(revision *models.PostRevision) string {
+	return revision.Title + "\n\n" + revision.Content
+}
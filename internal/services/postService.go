@@ -4,27 +4,64 @@ import (
 	"blog/internal/models"
 	"blog/internal/repository"
 	"errors"
+	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxPostContentSize is the largest post content in bytes.
+	MaxPostContentSize = 256 << 10
+	// maxPostTitleLength is the longest post title in characters.
+	maxPostTitleLength = 300
+)
+
+var (
+	ErrPostNotFound  = errors.New("post not found")
+	ErrPostForbidden = errors.New("you can only change your own posts")
+	ErrInvalidPost   = errors.New("invalid post")
 )
 
 type PostService struct {
 	PostRepository *repository.PostRepository
+	UserRepository *repository.UserRepository
 }
 
-func NewPostService(postRepository *repository.PostRepository) *PostService {
-	return &PostService{PostRepository: postRepository}
+func NewPostService(postRepository *repository.PostRepository, userRepository *repository.UserRepository) *PostService {
+	return &PostService{PostRepository: postRepository, UserRepository: userRepository}
 }
 
 // This method creates a new post.
-// It sets the user ID in the post and saves it to the repository.
-// It returns an error if the post creation fails.
+// It sets the user ID in the post and saves it to the repository. Posts without a status are published right away.
+// It returns ErrInvalidPost if the status or publication time is invalid, or an error if the post creation fails.
 func (p *PostService) NewPost(post *models.Post, userID uuid.UUID) error {
 
+	// The ID and timestamps are assigned by the database; listings and cursors are ordered by them.
+	post.ID = 0
+	post.CreatedAt, post.UpdatedAt, post.PublishedAt = time.Time{}, time.Time{}, time.Time{}
+	post.Hidden = false
 	post.UserID = userID
 
+	if err := validatePostSize(post); err != nil {
+		return err
+	}
+
+	status := post.Status
+	if status == "" {
+		status = models.PostPublished
+	}
+	publishAt := post.PublishAt
+	post.Status, post.PublishAt = "", nil
+	if err := setPostStatus(post, status, publishAt, time.Now()); err != nil {
+		return err
+	}
+
 	err := p.PostRepository.CreatePost(post)
 	if err != nil {
 		log.Printf("Failed to create post for user %s: %v", userID.String(), err)
@@ -35,10 +72,12 @@ func (p *PostService) NewPost(post *models.Post, userID uuid.UUID) error {
 	return nil
 }
 
-// This method retrieves all posts for the specified user.
+// This method retrieves a page of the posts for the specified user.
 // It converts the user's string ID to UUID and fetches the posts associated with that user.
+// Other users only get the published posts, while the user themselves also gets their drafts, scheduled, unlisted and private posts.
+// The viewer is uuid.Nil for anonymous requests.
 // It returns an error if the user ID conversion fails or if fetching posts fails.
-func (p *PostService) GetPosts(userIDstr string) ([]models.Post, error) {
+func (p *PostService) GetPosts(userIDstr string, viewerID uuid.UUID, page repository.Page) (*models.Page[models.Post], error) {
 
 	userID, err := uuid.Parse(userIDstr)
 	if err != nil {
@@ -46,28 +85,199 @@ func (p *PostService) GetPosts(userIDstr string) ([]models.Post, error) {
 		return nil, errors.New("invalid user ID " + err.Error())
 	}
 
-	posts, err := p.PostRepository.GetPosts(userID)
+	page = pageLimit(page)
+	posts, err := p.PostRepository.GetPosts(userID, userID == viewerID, time.Now(), page)
 	if err != nil {
 		log.Printf("Failed to retrieve posts for user %s: %v", userID.String(), err)
 		return nil, errors.New("failed to get posts " + err.Error())
 	}
 
 	log.Printf("Successfully retrieved posts for user %s", userID.String())
-	return posts, nil
+	return newPage(posts, page.Limit, postCursor), nil
+}
+
+// This method retrieves all posts of the user, newest first, for the unpaginated GET /posts/{userID} route.
+// It reads them page by page, so the same posts are returned as GetPosts returns across all pages.
+func (p *PostService) GetAllPosts(userIDstr string, viewerID uuid.UUID) ([]models.Post, error) {
+
+	all := []models.Post{}
+	page := repository.Page{Limit: maxPageSize}
+	for {
+		posts, err := p.GetPosts(userIDstr, viewerID, page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, posts.Items...)
+		if posts.NextCursor == "" {
+			return all, nil
+		}
+
+		next := postCursor(posts.Items[len(posts.Items)-1])
+		page.After = &next
+	}
+}
+
+// This method retrieves all posts of the user with the given handle, ignoring case, or with the given user ID,
+// as GetPosts does. It returns ErrUserNotFound if there is no such user,
+// or a UsernameMovedError if the user has changed their handle since.
+func (p *PostService) GetPostsByUsername(username string, viewerID uuid.UUID, page repository.Page) (*models.Page[models.Post], error) {
+
+	if userID, err := uuid.Parse(username); err == nil {
+		if _, err := p.UserRepository.GetUserByID(userID); err != nil {
+			return nil, userLookupError(username, err)
+		}
+		return p.GetPosts(userID.String(), viewerID, page)
+	}
+
+	user, err := resolveUsername(p.UserRepository, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.GetPosts(user.ID.String(), viewerID, page)
+}
+
+// This method retrieves a page of the published posts of all users, with a summary of their authors and their comment counts.
+func (p *PostService) GetFeed(page repository.Page) (*models.Page[models.PostDetail], error) {
+
+	page = pageLimit(page)
+	posts, err := p.PostRepository.GetFeed(time.Now(), page)
+	if err != nil {
+		log.Printf("Failed to retrieve the feed: %v", err)
+		return nil, errors.New("failed to get feed " + err.Error())
+	}
+
+	details, err := p.postDetails(posts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPage(details, page.Limit, func(detail models.PostDetail) repository.Cursor {
+		return postCursor(detail.Post)
+	}), nil
+}
+
+// This method retrieves a single post together with a summary of its author and its comment count.
+// Drafts, private and not yet published scheduled posts are only returned to their author.
+// It returns ErrPostNotFound if there is no such post, if the viewer may not see it, or if it has been hidden by a moderator.
+func (p *PostService) GetPost(postIDStr string, viewerID uuid.UUID) (*models.PostDetail, error) {
+
+	post, err := p.getPost(postIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if !post.VisibleTo(viewerID, time.Now()) {
+		return nil, ErrPostNotFound
+	}
+
+	details, err := p.postDetails([]models.Post{*post})
+	if err != nil {
+		return nil, err
+	}
+	return &details[0], nil
+}
+
+// postDetails adds the summary of the author and the comment count to each of the posts,
+// loading the authors and the counts of all posts at once.
+func (p *PostService) postDetails(posts []models.Post) ([]models.PostDetail, error) {
+
+	if len(posts) == 0 {
+		return nil, nil
+	}
+
+	userIDs := make([]uuid.UUID, 0, len(posts))
+	postIDs := make([]uint, 0, len(posts))
+	for _, post := range posts {
+		userIDs = append(userIDs, post.UserID)
+		postIDs = append(postIDs, post.ID)
+	}
+
+	users, err := p.UserRepository.GetUsersByIDs(userIDs)
+	if err != nil {
+		log.Printf("Failed to get authors of posts: %v", err)
+		return nil, errors.New("failed to get authors " + err.Error())
+	}
+	authors := make(map[uuid.UUID]models.PostAuthor, len(users))
+	for i := range users {
+		authors[users[i].ID] = users[i].PostAuthor()
+	}
+
+	commentCounts, err := p.PostRepository.CountCommentsByPost(postIDs)
+	if err != nil {
+		log.Printf("Failed to count comments of posts: %v", err)
+		return nil, errors.New("failed to count comments " + err.Error())
+	}
+
+	details := make([]models.PostDetail, 0, len(posts))
+	for _, post := range posts {
+		details = append(details, models.PostDetail{
+			Post:         post,
+			Author:       authors[post.UserID],
+			CommentCount: commentCounts[post.ID],
+		})
+	}
+	return details, nil
+}
+
+// This method updates the title, content, status and publication time of a post. Nil values are left unchanged.
+// Only the author and moderators can edit a post; edits by moderators are recorded as moderation actions.
+// Every change of the title or content is kept as a new revision of the post.
+// It returns ErrPostNotFound if there is no such post or the user may not see it, ErrPostForbidden if the user may not edit it,
+// or ErrInvalidPost if the new title is empty or the status or publication time is invalid.
+func (p *PostService) UpdatePost(postIDStr string, userID uuid.UUID, title, content, status *string, publishAt *time.Time) (*models.Post, error) {
+
+	post, err := p.getPost(postIDStr)
+	if err != nil {
+		return nil, err
+	}
+
+	moderator, err := p.authorizeEdit(post, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := *post
+	if title != nil {
+		if strings.TrimSpace(*title) == "" {
+			return nil, fmt.Errorf("%w: title is required", ErrInvalidPost)
+		}
+		edited.Title = *title
+	}
+	if content != nil {
+		edited.Content = *content
+	}
+	if err := validatePostSize(&edited); err != nil {
+		return nil, err
+	}
+	if status != nil || publishAt != nil {
+		newStatus := post.Status
+		if status != nil {
+			newStatus = *status
+		}
+		if err := setPostStatus(&edited, newStatus, publishAt, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.savePost(post, &edited, userID, moderator, "")
 }
 
 // This method deletes a post with the specified ID.
-// It converts the post's string ID to a number and calls the repository to delete the post.
-// It returns an error if the post ID is invalid or if the post deletion fails.
+// Only the author can delete a post here, moderators use the moderation endpoints.
+// It returns ErrPostNotFound if there is no such post or the user may not see it,
+// or ErrPostForbidden if the post belongs to another user.
 func (p *PostService) DeletePost(postIDStr string, userID uuid.UUID) error {
 
-	postID, err := strconv.ParseUint(postIDStr, 10, 64)
+	post, err := p.getPost(postIDStr)
 	if err != nil {
-		log.Printf("Invalid post ID %s: %v", postIDStr, err)
-		return errors.New("invalid post Id" + err.Error())
+		return err
 	}
 
-	err = p.PostRepository.DeletePost(uint(postID), userID)
+	if post.UserID != userID {
+		return p.accessError(post, userID)
+	}
+
+	err = p.PostRepository.DeletePost(post.ID, userID)
 	if err != nil {
 		log.Printf("Failed to delete post %s for user %s: %v", postIDStr, userID.String(), err)
 		return errors.New("failed to delete post" + err.Error())
@@ -76,3 +286,105 @@ func (p *PostService) DeletePost(postIDStr string, userID uuid.UUID) error {
 	log.Printf("Successfully deleted post %s for user %s", postIDStr, userID.String())
 	return nil
 }
+
+// validatePostSize returns ErrInvalidPost if the title or content of the post is too long.
+// The limits also keep revisions small enough to be compared.
+func validatePostSize(post *models.Post) error {
+	if utf8.RuneCountInString(post.Title) > maxPostTitleLength {
+		return fmt.Errorf("%w: title is too long", ErrInvalidPost)
+	}
+	if len(post.Content) > MaxPostContentSize {
+		return fmt.Errorf("%w: content is too long", ErrInvalidPost)
+	}
+	return nil
+}
+
+// getPost parses the post ID and loads the post, returning ErrPostNotFound if it does not exist.
+func (p *PostService) getPost(postIDStr string) (*models.Post, error) {
+
+	postID, err := strconv.ParseUint(postIDStr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid post ID %s: %v", postIDStr, err)
+		return nil, ErrPostNotFound
+	}
+
+	post, err := p.PostRepository.GetPost(uint(postID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPostNotFound
+		}
+		log.Printf("Failed to get post %s: %v", postIDStr, err)
+		return nil, errors.New("failed to get post " + err.Error())
+	}
+	return post, nil
+}
+
+// authorizeEdit checks that the user may edit the post, which the author and moderators can.
+// It reports whether the user edits the post as a moderator.
+func (p *PostService) authorizeEdit(post *models.Post, userID uuid.UUID) (bool, error) {
+
+	if post.UserID == userID {
+		return false, nil
+	}
+
+	moderator, err := p.isModerator(userID)
+	if err != nil {
+		return false, err
+	}
+	if !moderator {
+		return false, p.accessError(post, userID)
+	}
+	return true, nil
+}
+
+// savePost stores the edited post, keeping a changed title or content as a new revision.
+// Edits by moderators are recorded as moderation actions with the given reason.
+// Nothing is stored if the post has not changed.
+func (p *PostService) savePost(post, edited *models.Post, editorID uuid.UUID, moderator bool, reason string) (*models.Post, error) {
+
+	if edited.Title == post.Title && edited.Content == post.Content &&
+		edited.Status == post.Status && sameTime(edited.PublishAt, post.PublishAt) {
+		return post, nil
+	}
+
+	var action *models.ModerationAction
+	if moderator {
+		action = &models.ModerationAction{
+			ModeratorID:  editorID,
+			Action:       models.ModerationEdit,
+			TargetType:   models.TargetPost,
+			TargetID:     post.ID,
+			TargetUserID: post.UserID,
+			Reason:       reason,
+		}
+	}
+
+	postIDStr := strconv.FormatUint(uint64(post.ID), 10)
+	if err := p.PostRepository.UpdatePost(edited, editorID, action); err != nil {
+		log.Printf("Failed to update post %s by user %s: %v", postIDStr, editorID.String(), err)
+		return nil, errors.New("failed to update post " + err.Error())
+	}
+
+	log.Printf("Successfully updated post %s by user %s", postIDStr, editorID.String())
+	return p.getPost(postIDStr)
+}
+
+// isModerator reports whether the user may change posts of other users.
+func (p *PostService) isModerator(userID uuid.UUID) (bool, error) {
+
+	user, err := p.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return false, userLookupError(userID.String(), err)
+	}
+	return user.Role == models.RoleModerator || user.Role == models.RoleAdmin, nil
+}
+
+// accessError returns the error for a user who may not change the post of another user.
+// Posts the user cannot see, such as hidden posts, drafts or private posts, are reported as not found,
+// as GetPost does, so that their existence is not revealed.
+func (p *PostService) accessError(post *models.Post, userID uuid.UUID) error {
+	if !post.VisibleTo(userID, time.Now()) {
+		return ErrPostNotFound
+	}
+	return ErrPostForbidden
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"blog/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// schedulerInterval is the longest time the scheduler sleeps before looking for newly scheduled posts.
+	schedulerInterval = time.Minute
+	// schedulerMinWait is the shortest time the scheduler sleeps, so it does not loop while posts are due
+	// but cannot be published. After failures the wait doubles up to schedulerInterval.
+	schedulerMinWait = time.Second
+)
+
+// setPostStatus changes the status of the post and sets its publication time accordingly.
+// Only scheduled posts take a publication time, which must be in the future; if it is nil, the current one is kept.
+// Published posts get the current time as their publication time, unless they were already published.
+// PublishedAt follows the publication time; posts without one keep the time they were last published, or now for new posts.
+func setPostStatus(post *models.Post, status string, publishAt *time.Time, now time.Time) error {
+
+	switch status {
+	case models.PostScheduled:
+		if publishAt == nil {
+			publishAt = post.PublishAt
+		}
+		if publishAt == nil || !publishAt.After(now) {
+			return fmt.Errorf("%w: publish_at must be in the future for scheduled posts", ErrInvalidPost)
+		}
+		post.PublishAt = publishAt
+	case models.PostPublished, models.PostDraft, models.PostUnlisted, models.PostPrivate:
+		if publishAt != nil {
+			return fmt.Errorf("%w: publish_at can only be set for scheduled posts", ErrInvalidPost)
+		}
+		if status != models.PostPublished {
+			post.PublishAt = nil
+		} else if !post.IsPublished(now) {
+			post.PublishAt = &now
+		}
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidPost, status)
+	}
+
+	post.Status = status
+	if post.PublishAt != nil {
+		post.PublishedAt = *post.PublishAt
+	} else if post.PublishedAt.IsZero() {
+		post.PublishedAt = now
+	}
+	return nil
+}
+
+// sameTime reports whether two optional times are equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// This method publishes scheduled posts when their publication time comes, until the context is cancelled.
+// Scheduled posts are stored in the database, so posts that became due while the server was down are published on start.
+// Listings show due posts even before the scheduler has changed their status, so other users see them on time.
+func (p *PostService) RunScheduler(ctx context.Context) {
+	failures := 0
+	for {
+		wait := schedulerInterval
+		next, err := p.publishDuePosts()
+		if err != nil {
+			failures++
+			wait = min(schedulerMinWait<<min(failures-1, 6), schedulerInterval)
+		} else {
+			failures = 0
+			if next != nil {
+				wait = min(max(time.Until(*next), schedulerMinWait), schedulerInterval)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// publishDuePosts publishes the scheduled posts whose time has come
+// and returns the publication time of the next scheduled post, or nil if there is none.
+// It returns an error if publishing or the lookup failed.
+func (p *PostService) publishDuePosts() (*time.Time, error) {
+
+	published, err := p.PostRepository.PublishDuePosts(time.Now())
+	if err != nil {
+		log.Printf("Failed to publish scheduled posts: %v", err)
+		return nil, err
+	}
+	if published > 0 {
+		log.Printf("Published %d scheduled posts", published)
+	}
+
+	next, err := p.PostRepository.NextPublishAt()
+	if err != nil {
+		log.Printf("Failed to get the next scheduled post: %v", err)
+		return nil, err
+	}
+	return next, nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// recordEvent appends an event to the security log. userID may be uuid.Nil for events without a known account.
+// A failure to write the log is only logged, it never fails the operation that caused the event.
+func (u *UserService) recordEvent(eventType string, userID uuid.UUID, email string, meta models.SessionMeta, details string) {
+	event := &models.SecurityEvent{
+		Type:      eventType,
+		Email:     email,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		Details:   details,
+	}
+	if userID != uuid.Nil {
+		event.UserID = &userID
+	}
+
+	if err := u.SecurityEvents.CreateEvent(event); err != nil {
+		log.Printf("Failed to record security event %s for %s: %v", eventType, email, err)
+	}
+}
+
+// This method returns a page of the security events of the user, newest first.
+func (u *UserService) GetSecurityEvents(userID uuid.UUID, page repository.Page) (*models.Page[models.SecurityEvent], error) {
+	return u.QuerySecurityEvents(repository.SecurityEventFilter{UserID: &userID, Page: page})
+}
+
+// This method returns a page of the security events of all users matching the filter, newest first. It is meant for admins only.
+func (u *UserService) QuerySecurityEvents(filter repository.SecurityEventFilter) (*models.Page[models.SecurityEvent], error) {
+
+	filter.Page = pageLimit(filter.Page)
+	events, err := u.SecurityEvents.GetEvents(filter)
+	if err != nil {
+		log.Printf("Failed to get security events: %v", err)
+		return nil, errors.New("failed to get security events " + err.Error())
+	}
+
+	return newPage(events, filter.Page.Limit, func(event models.SecurityEvent) repository.Cursor {
+		return repository.Cursor{Time: event.CreatedAt, ID: event.ID}
+	}), nil
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/utils"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrNoTwoFactorPending   = errors.New("no pending two-factor enrollment, start it again")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired login challenge, log in again")
+)
+
+const (
+	recoveryCodeCount = 10
+	// maxChallengeAttempts is the number of wrong codes after which a login challenge is invalidated.
+	maxChallengeAttempts = 5
+)
+
+// TOTPEnrollment is returned when the user starts enabling two-factor authentication.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// This method starts TOTP enrollment for the user.
+// It generates a new secret that stays pending until it is confirmed with ConfirmTOTPEnrollment.
+// It returns ErrTwoFactorEnabled if the user already uses two-factor authentication.
+func (u *UserService) BeginTOTPEnrollment(userID uuid.UUID) (*TOTPEnrollment, error) {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userID.String(), err)
+		return nil, errors.New("error while getting user " + err.Error())
+	}
+
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Failed to generate TOTP secret for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to generate TOTP secret " + err.Error())
+	}
+
+	if err := u.UserRepository.SetPendingTOTPSecret(userID.String(), secret); err != nil {
+		log.Printf("Failed to store TOTP secret for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to store TOTP secret " + err.Error())
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "blog"
+	}
+
+	log.Printf("TOTP enrollment started for user %s", user.Email)
+	return &TOTPEnrollment{Secret: secret, URI: utils.TOTPURI(issuer, user.Email, secret)}, nil
+}
+
+// This method completes TOTP enrollment with the first code from the authenticator app.
+// It enables two-factor authentication and returns the recovery codes, which are shown to the user only once.
+// The current session is moved to a new session ID, which is returned as well.
+func (u *UserService) ConfirmTOTPEnrollment(userID uuid.UUID, currentSessionID, code string, meta models.SessionMeta) ([]string, *IssuedSession, error) {
+
+	secret, err := u.UserRepository.GetPendingTOTPSecret(userID.String())
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil, ErrNoTwoFactorPending
+		}
+		log.Printf("Failed to get pending TOTP secret for user %s: %v", userID.String(), err)
+		return nil, nil, errors.New("failed to get pending TOTP secret " + err.Error())
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, u.Now())
+	if !ok {
+		log.Printf("Invalid TOTP code during enrollment for user %s", userID.String())
+		return nil, nil, ErrInvalidTwoFactorCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			log.Printf("Failed to generate recovery code for user %s: %v", userID.String(), err)
+			return nil, nil, errors.New("failed to generate recovery code " + err.Error())
+		}
+		codes[i] = code
+		hashes[i] = utils.HashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := u.UserRepository.EnableTOTP(userID, secret, hashes); err != nil {
+		log.Printf("Failed to enable TOTP for user %s: %v", userID.String(), err)
+		return nil, nil, errors.New("failed to enable TOTP " + err.Error())
+	}
+
+	if _, err := u.UserRepository.MarkTOTPStepUsed(userID.String(), step); err != nil {
+		log.Printf("Failed to mark TOTP code as used for user %s: %v", userID.String(), err)
+	}
+
+	u.recordEvent(models.EventTwoFactorEnabled, userID, "", meta, "")
+
+	session, err := u.rotateSession(userID, currentSessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("TOTP enabled for user %s", userID.String())
+	return codes, session, nil
+}
+
+// This method turns off two-factor authentication. The current password must be confirmed.
+func (u *UserService) DisableTOTP(userID uuid.UUID, password string, meta models.SessionMeta) error {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userID.String(), err)
+		return errors.New("error while getting user " + err.Error())
+	}
+
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if err := u.Passwords.Verify(password, user.Password); err != nil {
+		log.Printf("Wrong current password for user %s", user.Email)
+		return ErrWrongPassword
+	}
+
+	if err := u.UserRepository.DisableTOTP(userID); err != nil {
+		log.Printf("Failed to disable TOTP for user %s: %v", user.Email, err)
+		return errors.New("failed to disable TOTP " + err.Error())
+	}
+
+	u.recordEvent(models.EventTwoFactorDisabled, userID, user.Email, meta, "")
+
+	log.Printf("TOTP disabled for user %s", user.Email)
+	return nil
+}
+
+// This method completes a two-factor login started by LoginUser.
+// The code can be either a TOTP code or one of the user's unused recovery codes.
+// After too many wrong codes the challenge is invalidated and the user has to log in again.
+// Wrong codes also count towards the lockout of the email and IP address, which returns a LockoutError.
+func (u *UserService) CompleteTwoFactorLogin(challengeToken, code string, meta models.SessionMeta) (*LoginResult, error) {
+
+	user, rememberMe, err := exchangeChallenge(u.UserRepository, utils.HashToken(challengeToken), code, u.Now(),
+		func(user *models.User) error {
+			return u.checkLockout(user.Email, meta.IP)
+		},
+		func(user *models.User) error {
+			log.Printf("Invalid two-factor code for user %s", user.Email)
+			u.recordEvent(models.EventTwoFactorFailed, user.ID, user.Email, meta, "")
+
+			// Wrong codes count towards the lockout of the email and IP address like wrong passwords,
+			// so requesting a new challenge after every few guesses does not help.
+			if err := u.registerLoginFailure(user.Email, meta, user); !errors.Is(err, ErrInvalidCredentials) {
+				return err
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	meta.RememberMe = rememberMe
+	session, err := u.createSession(user, meta, "two-factor")
+	if err != nil {
+		return nil, err
+	}
+	u.resetLoginFailures(user.Email)
+
+	log.Printf("User %s logged in successfully with two-factor authentication", user.Email)
+	return &LoginResult{User: user, IssuedSession: *session}, nil
+}
+
+// createLoginChallenge issues the short-lived token the client exchanges, together with a second-factor code, for a session.
+func (u *UserService) createLoginChallenge(user *models.User, rememberMe bool) (*LoginResult, error) {
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		log.Printf("Failed to generate login challenge for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to generate login challenge " + err.Error())
+	}
+
+	if err := u.UserRepository.CreateLoginChallenge(utils.HashToken(token), user.ID.String(), rememberMe); err != nil {
+		log.Printf("Failed to store login challenge for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to store login challenge " + err.Error())
+	}
+
+	log.Printf("User %s passed the password step, waiting for the second factor", user.Email)
+	return &LoginResult{User: user, ChallengeToken: token}, nil
+}
+
+// twoFactorStore keeps the login challenges and second factors of users. It is implemented by repository.UserRepository.
+type twoFactorStore interface {
+	GetLoginChallenge(tokenHash string) (string, bool, error)
+	IncrementLoginChallengeAttempts(tokenHash string) (int64, error)
+	DeleteLoginChallenge(tokenHash string) error
+	GetUserByID(userID uuid.UUID) (*models.User, error)
+	MarkTOTPStepUsed(userID string, step int64) (bool, error)
+	UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error)
+}
+
+// exchangeChallenge checks the code against the login challenge at the given time and returns the user of the challenge
+// and its remember-me choice. A right code deletes the challenge, so it can be exchanged only once.
+// checkLockout is called before the code is checked and ends the exchange if it returns an error.
+// failed is called for every wrong code; if it returns an error, such as a LockoutError, the challenge is deleted
+// and the exchange ends with that error. Otherwise the wrong code is counted and ErrInvalidTwoFactorCode is returned,
+// or ErrInvalidChallenge once the challenge has been deleted after maxChallengeAttempts wrong codes.
+func exchangeChallenge(store twoFactorStore, tokenHash, code string, now time.Time,
+	checkLockout, failed func(*models.User) error) (*models.User, bool, error) {
+
+	userIDStr, rememberMe, err := store.GetLoginChallenge(tokenHash)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, ErrInvalidChallenge
+		}
+		log.Printf("Failed to get login challenge: %v", err)
+		return nil, false, errors.New("failed to get login challenge " + err.Error())
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		log.Printf("Invalid user ID %s in login challenge: %v", userIDStr, err)
+		return nil, false, ErrInvalidChallenge
+	}
+
+	user, err := store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userIDStr, err)
+		return nil, false, errors.New("error while getting user " + err.Error())
+	}
+
+	if err := checkLockout(user); err != nil {
+		return nil, false, err
+	}
+
+	ok, err := checkSecondFactor(store, user, code, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		if err := failed(user); err != nil {
+			var lockoutErr *LockoutError
+			if errors.As(err, &lockoutErr) {
+				if err := store.DeleteLoginChallenge(tokenHash); err != nil {
+					log.Printf("Failed to delete login challenge for user %s: %v", user.Email, err)
+				}
+			}
+			return nil, false, err
+		}
+
+		attempts, err := store.IncrementLoginChallengeAttempts(tokenHash)
+		if err != nil {
+			log.Printf("Failed to count two-factor attempts for user %s: %v", user.Email, err)
+		}
+		if attempts >= maxChallengeAttempts {
+			if err := store.DeleteLoginChallenge(tokenHash); err != nil {
+				log.Printf("Failed to delete login challenge for user %s: %v", user.Email, err)
+			}
+			return nil, false, ErrInvalidChallenge
+		}
+		return nil, false, ErrInvalidTwoFactorCode
+	}
+
+	if err := store.DeleteLoginChallenge(tokenHash); err != nil {
+		log.Printf("Failed to delete login challenge for user %s: %v", user.Email, err)
+		return nil, false, errors.New("failed to delete login challenge " + err.Error())
+	}
+	return user, rememberMe, nil
+}
+
+// checkSecondFactor validates a TOTP code at the given time or consumes a recovery code of the user.
+// TOTP codes are accepted only once, so an intercepted code cannot be replayed.
+func checkSecondFactor(store twoFactorStore, user *models.User, code string, now time.Time) (bool, error) {
+
+	if step, ok := utils.ValidateTOTP(user.TOTPSecret, code, now); ok {
+		fresh, err := store.MarkTOTPStepUsed(user.ID.String(), step)
+		if err != nil {
+			log.Printf("Failed to mark TOTP code as used for user %s: %v", user.Email, err)
+			return false, errors.New("failed to mark TOTP code as used " + err.Error())
+		}
+		return fresh, nil
+	}
+
+	used, err := store.UseRecoveryCode(user.ID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		log.Printf("Failed to use recovery code for user %s: %v", user.Email, err)
+		return false, errors.New("failed to use recovery code " + err.Error())
+	}
+	if used {
+		log.Printf("Recovery code used by user %s", user.Email)
+	}
+	return used, nil
+}
+
+// normalizeRecoveryCode makes recovery codes insensitive to case, spaces and dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
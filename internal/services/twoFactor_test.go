@@ -0,0 +1,499 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"blog/utils"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The CompleteTwoFactorLogin tests run against a real PostgreSQL and Redis, like the application.
+// The exchangeChallenge tests use an in-memory store and run offline.
+// Set TEST_POSTGRES_DSN (e.g. "host=localhost user=blog password=blog dbname=blog_test sslmode=disable")
+// and TEST_REDIS_ADDR (e.g. "localhost:6379") to run them; Redis databases 13 to 15 are used.
+// Every test works with a new user, so the tests do not interfere with each other or with earlier runs.
+
+// testSecret is the TOTP secret of the test users.
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// testNow is the fixed time of the service clock, far from the real time, so codes only match through UserService.Now.
+var testNow = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+func newTestUserService(t *testing.T) *UserService {
+	t.Helper()
+
+	dsn, addr := os.Getenv("TEST_POSTGRES_DSN"), os.Getenv("TEST_REDIS_ADDR")
+	if dsn == "" || addr == "" {
+		t.Skip("TEST_POSTGRES_DSN and TEST_REDIS_ADDR are not set")
+	}
+
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("connecting to PostgreSQL: %v", err)
+	}
+	if err := database.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatalf("creating uuid-ossp extension: %v", err)
+	}
+	if err := database.AutoMigrate(&models.User{}, &models.RecoveryCode{}, &models.SecurityEvent{}); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	clients := make([]*redis.Client, 3)
+	for i := range clients {
+		clients[i] = redis.NewClient(&redis.Options{Addr: addr, DB: 13 + i})
+		t.Cleanup(func() { clients[i].Close() })
+	}
+
+	users := repository.NewUserRepository(database, clients[0], clients[1])
+	u := NewUserService(users, repository.NewLoginAttemptRepository(clients[2]), repository.NewSecurityEventRepository(database),
+		nil, nil, false)
+	u.Now = func() time.Time { return testNow }
+	return u
+}
+
+// newTwoFactorUser creates a user with TOTP enabled and returns it together with its recovery codes.
+func newTwoFactorUser(t *testing.T, u *UserService) (*models.User, []string) {
+	t.Helper()
+
+	id := uuid.New()
+	user := &models.User{ID: id, Email: id.String() + "@example.com", Password: "-", IsVerified: true, Role: models.RoleUser}
+	if err := u.UserRepository.CreateUser(user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	codes := make([]string, 3)
+	hashes := make([]string, len(codes))
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes[i] = code
+		hashes[i] = utils.HashToken(normalizeRecoveryCode(code))
+	}
+	if err := u.UserRepository.EnableTOTP(user.ID, testSecret, hashes); err != nil {
+		t.Fatalf("enabling TOTP: %v", err)
+	}
+	user.TOTPSecret = testSecret
+	user.TOTPEnabled = true
+	return user, codes
+}
+
+// newChallenge starts a two-factor login for the user, as LoginUser does after the password step.
+func newChallenge(t *testing.T, u *UserService, user *models.User) string {
+	t.Helper()
+
+	result, err := u.createLoginChallenge(user, false)
+	if err != nil {
+		t.Fatalf("creating login challenge: %v", err)
+	}
+	return result.ChallengeToken
+}
+
+// testMeta returns request metadata with an IP address of its own, so IP lockouts of other tests do not apply.
+func testMeta() models.SessionMeta {
+	id := uuid.New()
+	return models.SessionMeta{IP: "test-" + id.String(), UserAgent: "go test"}
+}
+
+func totpCode(t *testing.T, at time.Time) string {
+	t.Helper()
+
+	code, err := utils.TOTPCode(testSecret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCompleteTwoFactorLoginTOTP(t *testing.T) {
+	u := newTestUserService(t)
+
+	tests := []struct {
+		name    string
+		codeAt  time.Time
+		wantErr error
+	}{
+		{name: "current code", codeAt: testNow},
+		{name: "code of the previous period", codeAt: testNow.Add(-30 * time.Second)},
+		{name: "code of the next period", codeAt: testNow.Add(30 * time.Second)},
+		{name: "code outside the window", codeAt: testNow.Add(-2 * time.Minute), wantErr: ErrInvalidTwoFactorCode},
+		{name: "code of the real clock", codeAt: time.Now(), wantErr: ErrInvalidTwoFactorCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, _ := newTwoFactorUser(t, u)
+
+			result, err := u.CompleteTwoFactorLogin(newChallenge(t, u, user), totpCode(t, tt.codeAt), testMeta())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompleteTwoFactorLogin() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && result.SessionID == "" {
+				t.Error("CompleteTwoFactorLogin() returned no session")
+			}
+		})
+	}
+}
+
+func TestCompleteTwoFactorLoginRejectsReusedStep(t *testing.T) {
+	u := newTestUserService(t)
+	user, _ := newTwoFactorUser(t, u)
+	code := totpCode(t, testNow)
+
+	if _, err := u.CompleteTwoFactorLogin(newChallenge(t, u, user), code, testMeta()); err != nil {
+		t.Fatalf("first login error = %v", err)
+	}
+
+	// The same code is still inside the window, but its time step was used already.
+	_, err := u.CompleteTwoFactorLogin(newChallenge(t, u, user), code, testMeta())
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed code error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// A code of the next period is still accepted.
+	if _, err := u.CompleteTwoFactorLogin(newChallenge(t, u, user), totpCode(t, testNow.Add(30*time.Second)), testMeta()); err != nil {
+		t.Fatalf("login with the next code error = %v", err)
+	}
+}
+
+func TestCompleteTwoFactorLoginRecoveryCode(t *testing.T) {
+	u := newTestUserService(t)
+	user, codes := newTwoFactorUser(t, u)
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{name: "recovery code", code: codes[0]},
+		{name: "used recovery code", code: codes[0], wantErr: ErrInvalidTwoFactorCode},
+		{name: "recovery code in upper case with spaces", code: " " + strings.ToUpper(codes[1]) + " "},
+		{name: "unknown recovery code", code: "aaaa-bbbb-cccc", wantErr: ErrInvalidTwoFactorCode},
+		{name: "another unused recovery code", code: codes[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := u.CompleteTwoFactorLogin(newChallenge(t, u, user), tt.code, testMeta())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompleteTwoFactorLogin(%q) error = %v, want %v", tt.code, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompleteTwoFactorLoginLockout(t *testing.T) {
+	u := newTestUserService(t)
+	user, _ := newTwoFactorUser(t, u)
+	challenge := newChallenge(t, u, user)
+	meta := testMeta()
+	if maxEmailLoginFailures > maxChallengeAttempts {
+		t.Fatalf("the challenge ends after %d wrong codes, before the email is locked", maxChallengeAttempts)
+	}
+
+	// The user and the IP address are new, so only the wrong codes below count towards the lockout of the email.
+	for attempt := 1; attempt < maxEmailLoginFailures; attempt++ {
+		_, err := u.CompleteTwoFactorLogin(challenge, "000000", meta)
+		if !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("wrong code %d error = %v, want ErrInvalidTwoFactorCode", attempt, err)
+		}
+	}
+
+	// The wrong code that reaches the limit of the email locks it for the first lockout duration and ends the challenge.
+	_, err := u.CompleteTwoFactorLogin(challenge, "000000", meta)
+	var lockoutErr *LockoutError
+	if !errors.As(err, &lockoutErr) {
+		t.Fatalf("wrong code %d error = %v, want a LockoutError", maxEmailLoginFailures, err)
+	}
+	if lockoutErr.RetryAfter != baseLockout {
+		t.Errorf("RetryAfter = %s, want %s", lockoutErr.RetryAfter, baseLockout)
+	}
+
+	// The challenge is gone, so even the right code does not log in anymore.
+	if err := u.LoginAttempts.Reset("email:" + user.Email); err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.CompleteTwoFactorLogin(challenge, totpCode(t, testNow), testMeta())
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("right code after the limit error = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestCompleteTwoFactorLoginAttemptLimit(t *testing.T) {
+	u := newTestUserService(t)
+	user, _ := newTwoFactorUser(t, u)
+	challenge := newChallenge(t, u, user)
+	meta := testMeta()
+
+	for attempt := 1; attempt <= maxChallengeAttempts; attempt++ {
+		// Clearing the failures of the email keeps the lockout out of the way, so only the challenge limit applies.
+		if err := u.LoginAttempts.Reset("email:" + user.Email); err != nil {
+			t.Fatal(err)
+		}
+
+		want := ErrInvalidTwoFactorCode
+		if attempt == maxChallengeAttempts {
+			want = ErrInvalidChallenge
+		}
+		if _, err := u.CompleteTwoFactorLogin(challenge, "000000", meta); !errors.Is(err, want) {
+			t.Fatalf("wrong code %d error = %v, want %v", attempt, err, want)
+		}
+	}
+
+	_, err := u.CompleteTwoFactorLogin(challenge, totpCode(t, testNow), testMeta())
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("right code after the limit error = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestCompleteTwoFactorLoginInvalidChallenge(t *testing.T) {
+	u := newTestUserService(t)
+
+	_, err := u.CompleteTwoFactorLogin("unknown-challenge", "000000", testMeta())
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("CompleteTwoFactorLogin() error = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := map[string]string{
+		"abcd-efgh":     "abcdefgh",
+		"ABCD-EFGH":     "abcdefgh",
+		" abcd efgh ":   "abcdefgh",
+		"a-b-c-d e f g": "abcdefg",
+	}
+	for code, want := range tests {
+		if got := normalizeRecoveryCode(code); got != want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+// fakeTwoFactorStore keeps challenges, used TOTP steps and recovery codes in memory.
+type fakeTwoFactorStore struct {
+	users      map[uuid.UUID]*models.User
+	challenges map[string]*fakeChallenge
+	usedSteps  map[int64]bool
+	recovery   map[string]bool
+}
+
+type fakeChallenge struct {
+	userID     string
+	rememberMe bool
+	attempts   int64
+}
+
+func newFakeTwoFactorStore(user *models.User, recoveryCodes ...string) *fakeTwoFactorStore {
+	store := &fakeTwoFactorStore{
+		users:      map[uuid.UUID]*models.User{user.ID: user},
+		challenges: map[string]*fakeChallenge{},
+		usedSteps:  map[int64]bool{},
+		recovery:   map[string]bool{},
+	}
+	for _, code := range recoveryCodes {
+		store.recovery[utils.HashToken(normalizeRecoveryCode(code))] = true
+	}
+	return store
+}
+
+func (f *fakeTwoFactorStore) GetLoginChallenge(tokenHash string) (string, bool, error) {
+	challenge, ok := f.challenges[tokenHash]
+	if !ok {
+		return "", false, redis.Nil
+	}
+	return challenge.userID, challenge.rememberMe, nil
+}
+
+func (f *fakeTwoFactorStore) IncrementLoginChallengeAttempts(tokenHash string) (int64, error) {
+	f.challenges[tokenHash].attempts++
+	return f.challenges[tokenHash].attempts, nil
+}
+
+func (f *fakeTwoFactorStore) DeleteLoginChallenge(tokenHash string) error {
+	delete(f.challenges, tokenHash)
+	return nil
+}
+
+func (f *fakeTwoFactorStore) GetUserByID(userID uuid.UUID) (*models.User, error) {
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeTwoFactorStore) MarkTOTPStepUsed(userID string, step int64) (bool, error) {
+	if f.usedSteps[step] {
+		return false, nil
+	}
+	f.usedSteps[step] = true
+	return true, nil
+}
+
+func (f *fakeTwoFactorStore) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	if !f.recovery[codeHash] {
+		return false, nil
+	}
+	delete(f.recovery, codeHash)
+	return true, nil
+}
+
+// newFakeChallenge stores a challenge for the user and returns the hash of its token.
+func (f *fakeTwoFactorStore) newFakeChallenge(user *models.User, rememberMe bool) string {
+	tokenHash := utils.HashToken(uuid.NewString())
+	f.challenges[tokenHash] = &fakeChallenge{userID: user.ID.String(), rememberMe: rememberMe}
+	return tokenHash
+}
+
+func newOfflineTwoFactorUser() *models.User {
+	return &models.User{ID: uuid.New(), Email: "alice@example.com", TOTPSecret: testSecret, TOTPEnabled: true}
+}
+
+// noLockout is the lockout check and failure handler of an exchange that never locks the user out.
+func noLockout(*models.User) error { return nil }
+
+func TestExchangeChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    func(t *testing.T) string
+		wantErr error
+	}{
+		{name: "current code", code: func(t *testing.T) string { return totpCode(t, testNow) }},
+		{name: "code of the previous period", code: func(t *testing.T) string { return totpCode(t, testNow.Add(-30*time.Second)) }},
+		{name: "code outside the window", code: func(t *testing.T) string { return totpCode(t, testNow.Add(-2*time.Minute)) }, wantErr: ErrInvalidTwoFactorCode},
+		{name: "recovery code", code: func(*testing.T) string { return "ABCD-EFGH-IJKL" }},
+		{name: "wrong code", code: func(*testing.T) string { return "000000" }, wantErr: ErrInvalidTwoFactorCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newOfflineTwoFactorUser()
+			store := newFakeTwoFactorStore(user, "abcd-efgh-ijkl")
+			tokenHash := store.newFakeChallenge(user, true)
+
+			got, rememberMe, err := exchangeChallenge(store, tokenHash, tt.code(t), testNow, noLockout, noLockout)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("exchangeChallenge() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := store.challenges[tokenHash]; !ok {
+					t.Error("challenge was deleted after a wrong code")
+				}
+				return
+			}
+
+			if got != user || !rememberMe {
+				t.Errorf("exchangeChallenge() = %v, %v, want the user of the challenge and remember-me", got, rememberMe)
+			}
+			if _, ok := store.challenges[tokenHash]; ok {
+				t.Error("challenge still exists after a right code")
+			}
+		})
+	}
+}
+
+func TestExchangeChallengeOnlyOnce(t *testing.T) {
+	user := newOfflineTwoFactorUser()
+	store := newFakeTwoFactorStore(user)
+	tokenHash := store.newFakeChallenge(user, false)
+	code := totpCode(t, testNow)
+
+	if _, _, err := exchangeChallenge(store, tokenHash, code, testNow, noLockout, noLockout); err != nil {
+		t.Fatalf("first exchange error = %v", err)
+	}
+	if _, _, err := exchangeChallenge(store, tokenHash, code, testNow, noLockout, noLockout); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("second exchange of the challenge error = %v, want ErrInvalidChallenge", err)
+	}
+
+	// A new challenge does not accept the code again, as its time step was used already.
+	_, _, err := exchangeChallenge(store, store.newFakeChallenge(user, false), code, testNow, noLockout, noLockout)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed code error = %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
+
+func TestExchangeChallengeAttemptLimit(t *testing.T) {
+	user := newOfflineTwoFactorUser()
+	store := newFakeTwoFactorStore(user)
+	tokenHash := store.newFakeChallenge(user, false)
+
+	failures := 0
+	failed := func(*models.User) error {
+		failures++
+		return nil
+	}
+
+	for attempt := 1; attempt <= maxChallengeAttempts; attempt++ {
+		want := ErrInvalidTwoFactorCode
+		if attempt == maxChallengeAttempts {
+			want = ErrInvalidChallenge
+		}
+		if _, _, err := exchangeChallenge(store, tokenHash, "000000", testNow, noLockout, failed); !errors.Is(err, want) {
+			t.Fatalf("wrong code %d error = %v, want %v", attempt, err, want)
+		}
+	}
+	if failures != maxChallengeAttempts {
+		t.Errorf("failed was called %d times, want %d", failures, maxChallengeAttempts)
+	}
+
+	_, _, err := exchangeChallenge(store, tokenHash, totpCode(t, testNow), testNow, noLockout, noLockout)
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("right code after the limit error = %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestExchangeChallengeLockout(t *testing.T) {
+	user := newOfflineTwoFactorUser()
+	lockout := &LockoutError{RetryAfter: baseLockout}
+
+	t.Run("locked before the code is checked", func(t *testing.T) {
+		store := newFakeTwoFactorStore(user)
+		tokenHash := store.newFakeChallenge(user, false)
+		locked := func(*models.User) error { return lockout }
+
+		_, _, err := exchangeChallenge(store, tokenHash, totpCode(t, testNow), testNow, locked, noLockout)
+		if err != lockout {
+			t.Fatalf("exchangeChallenge() error = %v, want the LockoutError", err)
+		}
+		if len(store.usedSteps) != 0 {
+			t.Error("the code was checked while the user is locked out")
+		}
+		if _, ok := store.challenges[tokenHash]; !ok {
+			t.Error("challenge was deleted while the user is locked out")
+		}
+	})
+
+	t.Run("locked by a wrong code", func(t *testing.T) {
+		store := newFakeTwoFactorStore(user)
+		tokenHash := store.newFakeChallenge(user, false)
+		locks := func(*models.User) error { return lockout }
+
+		_, _, err := exchangeChallenge(store, tokenHash, "000000", testNow, noLockout, locks)
+		if err != lockout {
+			t.Fatalf("exchangeChallenge() error = %v, want the LockoutError", err)
+		}
+		if _, ok := store.challenges[tokenHash]; ok {
+			t.Error("challenge still exists after the lockout")
+		}
+	})
+}
+
+func TestExchangeChallengeUnknown(t *testing.T) {
+	user := newOfflineTwoFactorUser()
+	store := newFakeTwoFactorStore(user)
+
+	_, _, err := exchangeChallenge(store, utils.HashToken("unknown-challenge"), totpCode(t, testNow), testNow, noLockout, noLockout)
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("exchangeChallenge() error = %v, want ErrInvalidChallenge", err)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"blog/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxDisplayNameLength = 100
+	maxBioLength         = 1000
+)
+
+var ErrInvalidProfile = errors.New("invalid profile")
+
+// This method returns the public profile of the user with the given ID.
+// It returns ErrUserNotFound if there is no such user.
+func (u *UserService) GetPublicProfile(userIDstr string) (*models.PublicProfile, error) {
+
+	userID, err := uuid.Parse(userIDstr)
+	if err != nil {
+		log.Printf("Invalid user ID %s: %v", userIDstr, err)
+		return nil, ErrUserNotFound
+	}
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, userLookupError(userIDstr, err)
+	}
+
+	return u.publicProfile(user)
+}
+
+// This method returns the public profile of the user with the given username, ignoring case.
+// It returns ErrUserNotFound if there is no such user,
+// or a UsernameMovedError if the user has changed their handle since.
+func (u *UserService) GetPublicProfileByUsername(username string) (*models.PublicProfile, error) {
+
+	user, err := resolveUsername(u.UserRepository, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.publicProfile(user)
+}
+
+// This method returns the owner-only view of the user's own profile, including the email.
+func (u *UserService) GetOwnProfile(userID uuid.UUID) (*models.OwnerProfile, error) {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, userLookupError(userID.String(), err)
+	}
+
+	postCount, err := u.UserRepository.CountPosts(user.ID)
+	if err != nil {
+		log.Printf("Failed to count posts of user %s: %v", user.ID.String(), err)
+		return nil, errors.New("failed to count posts " + err.Error())
+	}
+
+	profile := user.OwnerProfile(postCount)
+	return &profile, nil
+}
+
+// This method updates the display name and bio of the user. Nil values are left unchanged.
+// It returns ErrInvalidProfile if a value is too long.
+func (u *UserService) UpdateProfile(userID uuid.UUID, displayName, bio *string) (*models.OwnerProfile, error) {
+
+	fields := map[string]interface{}{}
+	if displayName != nil {
+		if utf8.RuneCountInString(*displayName) > maxDisplayNameLength {
+			return nil, fmt.Errorf("%w: display name is too long", ErrInvalidProfile)
+		}
+		fields["display_name"] = *displayName
+	}
+	if bio != nil {
+		if utf8.RuneCountInString(*bio) > maxBioLength {
+			return nil, fmt.Errorf("%w: bio is too long", ErrInvalidProfile)
+		}
+		fields["bio"] = *bio
+	}
+
+	if len(fields) > 0 {
+		if err := u.UserRepository.UpdateProfile(userID, fields); err != nil {
+			log.Printf("Failed to update profile of user %s: %v", userID.String(), err)
+			return nil, errors.New("failed to update profile " + err.Error())
+		}
+		log.Printf("Profile of user %s updated successfully", userID.String())
+	}
+
+	return u.GetOwnProfile(userID)
+}
+
+// publicProfile builds the public profile of the user together with their post count.
+func (u *UserService) publicProfile(user *models.User) (*models.PublicProfile, error) {
+
+	postCount, err := u.UserRepository.CountPosts(user.ID)
+	if err != nil {
+		log.Printf("Failed to count posts of user %s: %v", user.ID.String(), err)
+		return nil, errors.New("failed to count posts " + err.Error())
+	}
+
+	profile := user.PublicProfile(postCount)
+	return &profile, nil
+}
+
+// userLookupError turns a "record not found" error into ErrUserNotFound.
+func userLookupError(user string, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUserNotFound
+	}
+	log.Printf("Error while getting user %s: %v", user, err)
+	return errors.New("error while getting user " + err.Error())
+}
@@ -3,27 +3,92 @@ package services
 import (
 	"blog/internal/models"
 	"blog/internal/repository"
+	"blog/storage"
 	"blog/utils"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"log"
+	"os"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrInvalidResetToken  = errors.New("invalid or expired reset token")
+	ErrPasswordRequired   = errors.New("password is required")
+	ErrWrongPassword      = errors.New("wrong current password")
+	ErrEmailTaken         = errors.New("email is already in use")
+	ErrNoEmailChange      = errors.New("no pending email change")
+	ErrWrongVerifyCode    = errors.New("wrong verify code")
+	ErrCodeExpired        = errors.New("verify code expired or was invalidated, request a new one")
+	ErrTooManyAttempts    = errors.New("too many wrong codes, request a new one")
+	ErrResendCooldown     = errors.New("a code was sent recently, try again later")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrEmailNotVerified   = errors.New("email not verified, request a new code via POST /verify/resend")
+)
+
+// maxVerifyAttempts is the number of wrong guesses after which a verification code is invalidated.
+const maxVerifyAttempts = 5
+
 type UserService struct {
 	UserRepository *repository.UserRepository
+	LoginAttempts  *repository.LoginAttemptRepository
+	SecurityEvents *repository.SecurityEventRepository
+	// Passwords hashes new passwords and verifies stored hashes of any supported algorithm.
+	Passwords utils.PasswordHasher
+	// Storage holds uploaded files of users, so they can be removed together with the account.
+	Storage storage.Storage
+	// RequireVerifiedEmail blocks login for users who have not verified their email.
+	RequireVerifiedEmail bool
+	// Now returns the current time. It is used for TOTP codes and can be replaced with a fixed clock.
+	Now func() time.Time
 }
 
-func NewUserService(userRepository *repository.UserRepository) *UserService {
-	return &UserService{UserRepository: userRepository}
+func NewUserService(userRepository *repository.UserRepository, loginAttempts *repository.LoginAttemptRepository,
+	securityEvents *repository.SecurityEventRepository, passwords utils.PasswordHasher, storage storage.Storage, requireVerifiedEmail bool) *UserService {
+	return &UserService{
+		UserRepository:       userRepository,
+		LoginAttempts:        loginAttempts,
+		SecurityEvents:       securityEvents,
+		Passwords:            passwords,
+		Storage:              storage,
+		RequireVerifiedEmail: requireVerifiedEmail,
+		Now:                  time.Now,
+	}
 }
 
 // This method handles user registration.
-// It hashes the user's password, generates a verification code, and stores the user and code in the database.
+// It checks that the username is a valid, unreserved and unused handle and that the password meets the password policy,
+// hashes the user's password, generates a verification code, and stores the user and code in the database.
 // It returns an error if any of the operations fail.
-func (u *UserService) RegisterUser(user *models.User) error {
+func (u *UserService) RegisterUser(user *models.User, meta models.SessionMeta) error {
+
+	// These fields are managed by the server and must not be set by the client.
+	user.IsVerified = false
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.Role = models.RoleUser
+
+	if err := validateUsername(user.Username); err != nil {
+		return err
+	}
+
+	if err := u.checkUsernameAvailable(user.Username, uuid.Nil); err != nil {
+		return err
+	}
 
-	hashedPassword, err := utils.HashPassword(user.Password)
+	if err := validatePassword(user.Password, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	hashedPassword, err := u.Passwords.Hash(user.Password)
 	if err != nil {
 		log.Printf("еrror while hashing password for user %s: %v", user.Email, err)
 		return errors.New("error while hashing password " + err.Error())
@@ -31,16 +96,22 @@ func (u *UserService) RegisterUser(user *models.User) error {
 
 	user.Password = string(hashedPassword)
 
+	// The user is created first, so a duplicate email cannot replace the verify code of the existing account.
+	if err := u.UserRepository.CreateUser(user); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return u.duplicateUserError(user.Email)
+		}
+		log.Printf("Error while creating user %s: %v", user.Email, err)
+		return errors.New("error while creating user " + err.Error())
+	}
+
 	verifyCode := utils.GenerateCode(6)
 	if err := u.UserRepository.CreateCode(user.Email, verifyCode); err != nil {
 		log.Printf("Error while creating verify code for user %s: %v", user.Email, err)
 		return errors.New("error while creating verify code " + err.Error())
 	}
 
-	if err := u.UserRepository.CreateUser(user); err != nil {
-		log.Printf("Error while creating user %s: %v", user.Email, err)
-		return errors.New("error while creating user " + err.Error())
-	}
+	u.recordEvent(models.EventRegistered, user.ID, user.Email, meta, "")
 
 	if err := utils.SendEmail(user.Email, verifyCode); err != nil {
 		log.Printf("Error while sending verify code %s: %v", user.Email, err)
@@ -51,10 +122,21 @@ func (u *UserService) RegisterUser(user *models.User) error {
 	return nil
 }
 
+// duplicateUserError tells which unique field a new user clashes with:
+// ErrEmailTaken if an account with the email exists, and ErrUsernameTaken otherwise.
+func (u *UserService) duplicateUserError(email string) error {
+	if _, err := u.UserRepository.GetUserByEmail(email); err == nil {
+		log.Printf("Email %s is already in use", email)
+		return ErrEmailTaken
+	}
+	log.Printf("Username for %s is already taken", email)
+	return ErrUsernameTaken
+}
+
 // This method handles email verification.
 // It checks the verification code provided by the user and updates the user's status to "verified."
 // It returns an error if the code is incorrect or if there are issues accessing the user's data.
-func (u *UserService) VerifyEmail(email, code string) error {
+func (u *UserService) VerifyEmail(email, code string, meta models.SessionMeta) error {
 
 	user, err := u.UserRepository.GetUserByEmail(email)
 	if err != nil {
@@ -64,13 +146,31 @@ func (u *UserService) VerifyEmail(email, code string) error {
 
 	verifyCode, err := u.UserRepository.GetCodeByEmail(email)
 	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			log.Printf("No verify code for email %s", email)
+			return ErrCodeExpired
+		}
 		log.Printf("Error while getting verify code for email %s: %v", email, err)
 		return errors.New("error while getting verify code by email " + err.Error())
 	}
 
-	if verifyCode != code {
+	if subtle.ConstantTimeCompare([]byte(verifyCode), []byte(code)) != 1 {
 		log.Printf("Invalid verify code for email %s", email)
-		return errors.New("wrong verify code")
+
+		attempts, err := u.UserRepository.IncrementCodeAttempts(email)
+		if err != nil {
+			log.Printf("Error while counting verify attempts for email %s: %v", email, err)
+			return errors.New("error while counting verify attempts " + err.Error())
+		}
+
+		if attempts >= maxVerifyAttempts {
+			if err := u.UserRepository.DeleteCode(email); err != nil {
+				log.Printf("Error while deleting verify code for email %s: %v", email, err)
+			}
+			log.Printf("Verify code for email %s invalidated after %d wrong attempts", email, attempts)
+			return ErrTooManyAttempts
+		}
+		return ErrWrongVerifyCode
 	}
 
 	user.IsVerified = true
@@ -80,41 +180,567 @@ func (u *UserService) VerifyEmail(email, code string) error {
 		return errors.New("failed to update user " + err.Error())
 	}
 
+	if err := u.UserRepository.DeleteCode(email); err != nil {
+		log.Printf("Error while deleting verify code for email %s: %v", email, err)
+	}
+
+	u.recordEvent(models.EventEmailVerified, user.ID, user.Email, meta, "")
+
 	log.Printf("User %s email verified successfully", email)
 	return nil
 }
 
+// This method sends a new verification code to a registered but not yet verified email.
+// Codes can be resent only once per cooldown period, otherwise ErrResendCooldown is returned.
+// Unknown or already verified emails are silently ignored, so the caller cannot enumerate accounts.
+func (u *UserService) ResendVerificationCode(email string) error {
+
+	started, err := u.UserRepository.StartResendCooldown(email)
+	if err != nil {
+		log.Printf("Error while starting resend cooldown for email %s: %v", email, err)
+		return errors.New("error while starting resend cooldown " + err.Error())
+	}
+	if !started {
+		log.Printf("Verify code for email %s was resent too recently", email)
+		return ErrResendCooldown
+	}
+
+	user, err := u.UserRepository.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Verify code requested for unknown email %s: %v", email, err)
+		return nil
+	}
+	if user.IsVerified {
+		log.Printf("Verify code requested for already verified email %s", email)
+		return nil
+	}
+
+	verifyCode := utils.GenerateCode(6)
+	if err := u.UserRepository.CreateCode(email, verifyCode); err != nil {
+		log.Printf("Error while creating verify code for user %s: %v", email, err)
+		return errors.New("error while creating verify code " + err.Error())
+	}
+
+	if err := utils.SendEmail(email, verifyCode); err != nil {
+		log.Printf("Error while sending verify code %s: %v", email, err)
+		return errors.New("error while sending verify code " + err.Error())
+	}
+
+	log.Printf("Verify code resent to %s", email)
+	return nil
+}
+
+// LoginResult is the outcome of a successful password check.
+// SessionID is set when the user is logged in, ChallengeToken when the login still has to be confirmed
+// with a second factor via CompleteTwoFactorLogin.
+type LoginResult struct {
+	User *models.User
+	IssuedSession
+	ChallengeToken string
+}
+
+// IssuedSession is a session created for the user, to be sent to the client as the session cookie.
+type IssuedSession struct {
+	SessionID string
+	// ExpiresAt is when a remember-me session ends for good.
+	// It is zero for other sessions, whose cookie lasts only until the browser is closed.
+	ExpiresAt time.Time
+}
+
 // This method handles the user login process.
 // It verifies the user's email and password, compares them with the stored data, and generates a session ID if the login is successful.
+// If the user has two-factor authentication enabled, a short-lived challenge token is returned instead of a session.
+// If verification is required, users with an unverified email get ErrEmailNotVerified.
+// Failed attempts are counted per email and per IP address; while either is locked out, a LockoutError is returned.
 // It returns an error if the user's credentials are incorrect or if session creation fails.
-func (u *UserService) LoginUser(email, password string) (*models.User, string, error) {
+func (u *UserService) LoginUser(email, password string, meta models.SessionMeta) (*LoginResult, error) {
+
+	if err := u.checkLockout(email, meta.IP); err != nil {
+		return nil, err
+	}
 
 	user, err := u.UserRepository.GetUserByEmail(email)
 	if err != nil {
 		log.Printf("User %s not found: %v", email, err)
-		return nil, "", errors.New("user not found")
+		return nil, u.loginFailed(email, meta, nil)
 	}
 
-	if err := utils.CheckPasswordHash(password, user.Password); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+	if err := u.Passwords.Verify(password, user.Password); err != nil {
+		if errors.Is(err, utils.ErrPasswordMismatch) {
 			log.Printf("Invalid password for user %s", email)
-			return nil, "", errors.New("invalid password")
+			return nil, u.loginFailed(email, meta, user)
 		}
 		log.Printf("Error comparing password and hash for user %s: %v", email, err)
-		return nil, "", errors.New("error comparing password and hash")
+		return nil, errors.New("error comparing password and hash")
+	}
+
+	u.rehashPassword(user, password)
+
+	if u.RequireVerifiedEmail && !user.IsVerified {
+		log.Printf("User %s tried to log in with unverified email", email)
+		return nil, ErrEmailNotVerified
 	}
 
+	// The failure counter is only reset once the login is complete,
+	// so guessing the second factor counts towards the lockout as well.
+	if user.TOTPEnabled {
+		return u.createLoginChallenge(user, meta.RememberMe)
+	}
+
+	session, err := u.createSession(user, meta, "password")
+	if err != nil {
+		return nil, err
+	}
+	u.resetLoginFailures(email)
+
+	log.Printf("User %s logged in successfully", email)
+	return &LoginResult{User: user, IssuedSession: *session}, nil
+}
+
+// createSession generates a new session ID for the user and stores the session.
+// The successful login is recorded in the security log together with the login method.
+func (u *UserService) createSession(user *models.User, meta models.SessionMeta, method string) (*IssuedSession, error) {
+
 	sessionID, err := utils.GenerateSessionID()
 	if err != nil {
-		log.Printf("Failed to generate session ID for user %s: %v", email, err)
-		return nil, "", errors.New("failed to generate session ID" + err.Error())
+		log.Printf("Failed to generate session ID for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to generate session ID" + err.Error())
 	}
 
-	if err := u.UserRepository.CreateSessionID(sessionID, user.ID.String()); err != nil {
-		log.Printf("Failed to create session for user %s: %v", email, err)
-		return nil, "", errors.New("failed to create session ID " + err.Error())
+	expiresAt, err := u.UserRepository.CreateSessionID(sessionID, user.ID.String(), meta)
+	if err != nil {
+		log.Printf("Failed to create session for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to create session ID " + err.Error())
 	}
 
-	log.Printf("User %s logged in successfully", email)
-	return user, sessionID, nil
+	u.recordEvent(models.EventLoginSucceeded, user.ID, user.Email, meta, "method: "+method)
+
+	session := &IssuedSession{SessionID: sessionID}
+	if meta.RememberMe {
+		session.ExpiresAt = expiresAt
+	}
+	return session, nil
+}
+
+// rotateSession moves the current session of the user to a new session ID after a privilege change,
+// so a session ID an attacker may have planted or observed before the change becomes useless.
+func (u *UserService) rotateSession(userID uuid.UUID, currentSessionID string) (*IssuedSession, error) {
+
+	sessionID, err := utils.GenerateSessionID()
+	if err != nil {
+		log.Printf("Failed to generate session ID for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to generate session ID" + err.Error())
+	}
+
+	rotated, err := u.UserRepository.RotateSession(currentSessionID, sessionID)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrSessionNotFound
+		}
+		log.Printf("Failed to rotate session for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to rotate session " + err.Error())
+	}
+
+	session := &IssuedSession{SessionID: sessionID}
+	if rotated.RememberMe {
+		session.ExpiresAt = rotated.ExpiresAt
+	}
+
+	log.Printf("Session of user %s rotated", userID.String())
+	return session, nil
+}
+
+// This method ends a single session of the user, e.g. the one the user is currently logged in with.
+// It returns an error if the session could not be removed from the store.
+func (u *UserService) Logout(userID uuid.UUID, sessionID string, meta models.SessionMeta) error {
+
+	if err := u.UserRepository.DeleteSession(sessionID); err != nil {
+		log.Printf("Failed to delete session: %v", err)
+		return errors.New("failed to delete session " + err.Error())
+	}
+
+	u.recordEvent(models.EventSessionRevoked, userID, "", meta, "session "+utils.HashToken(sessionID))
+
+	log.Printf("Session deleted successfully")
+	return nil
+}
+
+// This method revokes all sessions of the user except exceptSessionID.
+// An empty exceptSessionID revokes every session, including the current one.
+// It is also used after a password change to log out all other devices.
+func (u *UserService) RevokeSessions(userID uuid.UUID, exceptSessionID string, meta models.SessionMeta) error {
+
+	if err := u.UserRepository.DeleteUserSessions(userID.String(), exceptSessionID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", userID.String(), err)
+		return errors.New("failed to revoke sessions " + err.Error())
+	}
+
+	details := "all sessions"
+	if exceptSessionID != "" {
+		details = "all sessions except the current one"
+	}
+	u.recordEvent(models.EventSessionsRevoked, userID, "", meta, details)
+
+	log.Printf("Sessions of user %s revoked successfully", userID.String())
+	return nil
+}
+
+// This method returns all active sessions of the user.
+// The session the request was made with is marked as current.
+func (u *UserService) ListSessions(userID uuid.UUID, currentSessionID string) ([]models.Session, error) {
+
+	sessions, err := u.UserRepository.GetUserSessions(userID.String())
+	if err != nil {
+		log.Printf("Failed to get sessions for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to get sessions " + err.Error())
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == currentSessionID
+	}
+
+	log.Printf("Successfully retrieved sessions for user %s", userID.String())
+	return sessions, nil
+}
+
+// This method terminates one session of the user by its public ID.
+// It returns the session it terminated, or ErrSessionNotFound if the user has no such session.
+func (u *UserService) DeleteSession(userID uuid.UUID, id string, meta models.SessionMeta) (*models.Session, error) {
+
+	sessions, err := u.UserRepository.GetUserSessions(userID.String())
+	if err != nil {
+		log.Printf("Failed to get sessions for user %s: %v", userID.String(), err)
+		return nil, errors.New("failed to get sessions " + err.Error())
+	}
+
+	for _, session := range sessions {
+		if session.ID != id {
+			continue
+		}
+
+		if err := u.UserRepository.DeleteSession(session.SessionID); err != nil {
+			log.Printf("Failed to delete session for user %s: %v", userID.String(), err)
+			return nil, errors.New("failed to delete session " + err.Error())
+		}
+
+		u.recordEvent(models.EventSessionRevoked, userID, "", meta, "session "+session.ID)
+
+		log.Printf("Session of user %s deleted successfully", userID.String())
+		return &session, nil
+	}
+
+	return nil, ErrSessionNotFound
+}
+
+// This method starts the password recovery process.
+// If a user with the given email exists, it stores a single-use reset token and emails a reset link.
+// It does not report whether the account exists, so the caller must answer the same way in both cases.
+func (u *UserService) ForgotPassword(email string) error {
+
+	user, err := u.UserRepository.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Password reset requested for unknown email %s: %v", email, err)
+		return nil
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		log.Printf("Failed to generate reset token for user %s: %v", email, err)
+		return errors.New("failed to generate reset token " + err.Error())
+	}
+
+	if err := u.UserRepository.CreatePasswordResetToken(utils.HashToken(token), user.ID.String()); err != nil {
+		log.Printf("Failed to store reset token for user %s: %v", email, err)
+		return errors.New("failed to store reset token " + err.Error())
+	}
+
+	// The email is sent in the background so the response time does not reveal whether the account exists.
+	link := fmt.Sprintf("%s/password/reset?token=%s", os.Getenv("APP_BASE_URL"), token)
+	go func() {
+		body := fmt.Sprintf("To reset your password, follow the link below. It is valid for 30 minutes.\n\n%s\n\nIf you did not request a password reset, ignore this email.", link)
+		if err := utils.SendMail(user.Email, "Password reset", body); err != nil {
+			log.Printf("Error while sending reset link to %s: %v", user.Email, err)
+		}
+	}()
+
+	log.Printf("Password reset token created for user %s", email)
+	return nil
+}
+
+// This method sets a new password using a token from the password reset email.
+// The token is consumed on first use. After the password is changed, all sessions of the user are revoked.
+// It returns ErrInvalidResetToken if the token is unknown, expired or was already used.
+func (u *UserService) ResetPassword(token, newPassword string, meta models.SessionMeta) error {
+
+	// The email and username are not known before the token is consumed, so only the general rules are checked.
+	if err := validatePassword(newPassword, "", ""); err != nil {
+		return err
+	}
+
+	userIDStr, err := u.UserRepository.ConsumePasswordResetToken(utils.HashToken(token))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			log.Printf("Invalid or expired reset token")
+			return ErrInvalidResetToken
+		}
+		log.Printf("Failed to get reset token: %v", err)
+		return errors.New("failed to get reset token " + err.Error())
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		log.Printf("Invalid user ID %s in reset token: %v", userIDStr, err)
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := u.Passwords.Hash(newPassword)
+	if err != nil {
+		log.Printf("Error while hashing password for user %s: %v", userIDStr, err)
+		return errors.New("error while hashing password " + err.Error())
+	}
+
+	if err := u.UserRepository.UpdatePassword(userID, hashedPassword); err != nil {
+		log.Printf("Failed to update password for user %s: %v", userIDStr, err)
+		return errors.New("failed to update password " + err.Error())
+	}
+
+	u.recordEvent(models.EventPasswordReset, userID, "", meta, "")
+
+	if err := u.RevokeSessions(userID, "", meta); err != nil {
+		return err
+	}
+
+	log.Printf("Password of user %s reset successfully", userIDStr)
+	return nil
+}
+
+// This method changes the password of a logged in user.
+// The current password must be confirmed. After the change, all other sessions of the user are revoked,
+// while the session the request was made with is moved to a new session ID, which is returned.
+func (u *UserService) ChangePassword(userID uuid.UUID, currentSessionID, currentPassword, newPassword string, meta models.SessionMeta) (*IssuedSession, error) {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userID.String(), err)
+		return nil, errors.New("error while getting user " + err.Error())
+	}
+
+	if err := u.Passwords.Verify(currentPassword, user.Password); err != nil {
+		log.Printf("Wrong current password for user %s", user.Email)
+		return nil, ErrWrongPassword
+	}
+
+	if err := validatePassword(newPassword, user.Email, user.Username); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := u.Passwords.Hash(newPassword)
+	if err != nil {
+		log.Printf("Error while hashing password for user %s: %v", user.Email, err)
+		return nil, errors.New("error while hashing password " + err.Error())
+	}
+
+	if err := u.UserRepository.UpdatePassword(userID, hashedPassword); err != nil {
+		log.Printf("Failed to update password for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to update password " + err.Error())
+	}
+
+	u.recordEvent(models.EventPasswordChanged, userID, user.Email, meta, "")
+
+	if err := u.RevokeSessions(userID, currentSessionID, meta); err != nil {
+		return nil, err
+	}
+
+	session, err := u.rotateSession(userID, currentSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Password of user %s changed successfully", user.Email)
+	return session, nil
+}
+
+// This method starts an email change for a logged in user.
+// The current password must be confirmed. The old address stays active until the new one is confirmed
+// with the code sent to it by ConfirmEmailChange.
+func (u *UserService) RequestEmailChange(userID uuid.UUID, password, newEmail string) error {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userID.String(), err)
+		return errors.New("error while getting user " + err.Error())
+	}
+
+	if err := u.Passwords.Verify(password, user.Password); err != nil {
+		log.Printf("Wrong current password for user %s", user.Email)
+		return ErrWrongPassword
+	}
+
+	if _, err := u.UserRepository.GetUserByEmail(newEmail); err == nil {
+		log.Printf("Email %s is already in use", newEmail)
+		return ErrEmailTaken
+	}
+
+	verifyCode := utils.GenerateCode(6)
+	if err := u.UserRepository.CreateEmailChange(userID.String(), newEmail, verifyCode); err != nil {
+		log.Printf("Error while creating email change for user %s: %v", user.Email, err)
+		return errors.New("error while creating email change " + err.Error())
+	}
+
+	if err := utils.SendEmail(newEmail, verifyCode); err != nil {
+		log.Printf("Error while sending verify code %s: %v", newEmail, err)
+		return errors.New("error while sending verify code " + err.Error())
+	}
+
+	log.Printf("Email change requested for user %s", user.Email)
+	return nil
+}
+
+// This method completes a pending email change with the code sent to the new address.
+// The previous address is notified about the change, and the current session is moved to a new session ID, which is returned.
+// It returns ErrNoEmailChange if nothing is pending and ErrWrongVerifyCode if the code does not match.
+// After too many wrong codes the pending change is dropped and ErrTooManyAttempts is returned.
+func (u *UserService) ConfirmEmailChange(userID uuid.UUID, currentSessionID, code string, meta models.SessionMeta) (*IssuedSession, error) {
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error while getting user %s: %v", userID.String(), err)
+		return nil, errors.New("error while getting user " + err.Error())
+	}
+
+	newEmail, verifyCode, err := u.UserRepository.GetEmailChange(userID.String())
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNoEmailChange
+		}
+		log.Printf("Error while getting email change for user %s: %v", user.Email, err)
+		return nil, errors.New("error while getting email change " + err.Error())
+	}
+
+	if subtle.ConstantTimeCompare([]byte(verifyCode), []byte(code)) != 1 {
+		log.Printf("Invalid email change code for user %s", user.Email)
+
+		attempts, err := u.UserRepository.IncrementEmailChangeAttempts(userID.String())
+		if err != nil {
+			log.Printf("Error while counting email change attempts for user %s: %v", user.Email, err)
+			return nil, errors.New("error while counting email change attempts " + err.Error())
+		}
+
+		if attempts >= maxVerifyAttempts {
+			if err := u.UserRepository.DeleteEmailChange(userID.String()); err != nil {
+				log.Printf("Error while deleting email change for user %s: %v", user.Email, err)
+			}
+			log.Printf("Email change of user %s dropped after %d wrong attempts", user.Email, attempts)
+			return nil, ErrTooManyAttempts
+		}
+		return nil, ErrWrongVerifyCode
+	}
+
+	if _, err := u.UserRepository.GetUserByEmail(newEmail); err == nil {
+		log.Printf("Email %s is already in use", newEmail)
+		return nil, ErrEmailTaken
+	}
+
+	if err := u.UserRepository.UpdateEmail(userID, newEmail); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrEmailTaken
+		}
+		log.Printf("Failed to update email for user %s: %v", user.Email, err)
+		return nil, errors.New("failed to update email " + err.Error())
+	}
+
+	if err := u.UserRepository.DeleteEmailChange(userID.String()); err != nil {
+		log.Printf("Failed to delete email change for user %s: %v", newEmail, err)
+	}
+
+	u.recordEvent(models.EventEmailChanged, userID, newEmail, meta, "changed from "+user.Email)
+
+	body := fmt.Sprintf("The email address of your account was changed to %s. If you did not do this, reset your password immediately.", newEmail)
+	if err := utils.SendMail(user.Email, "Email address changed", body); err != nil {
+		log.Printf("Error while notifying %s about email change: %v", user.Email, err)
+	}
+
+	session, err := u.rotateSession(userID, currentSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Email of user %s changed to %s", user.Email, newEmail)
+	return session, nil
+}
+
+// This method changes the role of a user and revokes all of their sessions. It is meant to be called by admins only.
+// It returns ErrInvalidRole for unknown roles and ErrUserNotFound if there is no such user.
+func (u *UserService) SetRole(userIDstr, role string, adminID uuid.UUID, meta models.SessionMeta) error {
+
+	if role != models.RoleUser && role != models.RoleModerator && role != models.RoleAdmin {
+		return ErrInvalidRole
+	}
+
+	userID, err := uuid.Parse(userIDstr)
+	if err != nil {
+		log.Printf("Invalid user ID %s: %v", userIDstr, err)
+		return ErrUserNotFound
+	}
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		log.Printf("Error while getting user %s: %v", userIDstr, err)
+		return errors.New("error while getting user " + err.Error())
+	}
+
+	if err := u.UserRepository.UpdateRole(userID, role); err != nil {
+		log.Printf("Failed to update role of user %s: %v", userIDstr, err)
+		return errors.New("failed to update role " + err.Error())
+	}
+
+	details := fmt.Sprintf("role changed from %s to %s by admin %s", user.Role, role, adminID.String())
+	u.recordEvent(models.EventRoleChanged, userID, user.Email, meta, details)
+
+	// The user has to log in again, so sessions from before the change do not carry over the new privileges.
+	if err := u.RevokeSessions(userID, "", meta); err != nil {
+		return err
+	}
+
+	log.Printf("Admin %s set role of user %s to %s", adminID.String(), userIDstr, role)
+	return nil
+}
+
+// This method gives the admin role to the users with the given emails, so the first admin can be created
+// without an existing one. It is called at startup with the addresses from the ADMIN_EMAILS environment variable.
+// Only verified accounts are promoted, so nobody gets the role by registering an address they do not own.
+// Unknown and unverified addresses are skipped and logged; they are promoted at a later start.
+func (u *UserService) PromoteAdmins(emails []string) error {
+
+	for _, email := range emails {
+		user, err := u.UserRepository.GetUserByEmail(email)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("Admin %s has no account yet", email)
+				continue
+			}
+			log.Printf("Error while getting user %s: %v", email, err)
+			return errors.New("error while getting user " + err.Error())
+		}
+
+		if user.Role == models.RoleAdmin {
+			continue
+		}
+		if !user.IsVerified {
+			log.Printf("Admin %s has not verified their email yet", email)
+			continue
+		}
+
+		if err := u.UserRepository.UpdateRole(user.ID, models.RoleAdmin); err != nil {
+			log.Printf("Failed to update role of user %s: %v", email, err)
+			return errors.New("failed to update role " + err.Error())
+		}
+
+		u.recordEvent(models.EventRoleChanged, user.ID, user.Email, models.SessionMeta{}, "role changed from "+user.Role+" to admin by ADMIN_EMAILS")
+		log.Printf("User %s promoted to admin", email)
+	}
+	return nil
 }
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"blog/internal/models"
+	"blog/internal/repository"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidUsername  = errors.New("username must be 3-30 characters long and contain only letters, digits and underscores")
+	ErrReservedUsername = errors.New("username is reserved")
+	ErrUsernameTaken    = errors.New("username is already taken")
+	ErrUsernameCooldown = errors.New("username was changed recently, try again later")
+)
+
+const (
+	// usernameChangeCooldown is the minimum time between two handle changes of a user.
+	usernameChangeCooldown = 30 * 24 * time.Hour
+	// usernameRedirectPeriod is how long an old handle redirects to the new one and stays reserved for its former owner.
+	usernameRedirectPeriod = 180 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedUsernames are handles nobody can register, because they look official or clash with routes.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "api": true, "by-username": true, "feed": true,
+	"help": true, "login": true, "logout": true, "me": true, "mod": true, "moderation": true,
+	"moderator": true, "password": true, "posts": true, "root": true, "security": true,
+	"sessions": true, "settings": true, "support": true, "system": true, "users": true, "verify": true,
+}
+
+// ReservedUsernames returns the handles nobody can register, in lower case.
+func ReservedUsernames() []string {
+	usernames := make([]string, 0, len(reservedUsernames))
+	for username := range reservedUsernames {
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// UsernameMovedError is returned when a user is looked up by a handle they no longer use.
+// Username is their current handle, which the caller should redirect to.
+type UsernameMovedError struct {
+	Username string
+}
+
+func (e *UsernameMovedError) Error() string {
+	return "user moved to " + e.Username
+}
+
+// This method changes the handle of the user. Handles can be changed once per cooldown period;
+// the old handle keeps redirecting to the new one for a while.
+func (u *UserService) ChangeUsername(userID uuid.UUID, username string) (*models.OwnerProfile, error) {
+
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	user, err := u.UserRepository.GetUserByID(userID)
+	if err != nil {
+		return nil, userLookupError(userID.String(), err)
+	}
+
+	if user.Username == username {
+		return u.GetOwnProfile(userID)
+	}
+
+	if user.UsernameChangedAt != nil && time.Since(*user.UsernameChangedAt) < usernameChangeCooldown {
+		log.Printf("User %s changed username too recently", user.Email)
+		return nil, ErrUsernameCooldown
+	}
+
+	if !strings.EqualFold(user.Username, username) {
+		if err := u.checkUsernameAvailable(username, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := u.UserRepository.ChangeUsername(userID, user.Username, username); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrUsernameTaken
+		}
+		log.Printf("Failed to change username of user %s: %v", user.Email, err)
+		return nil, errors.New("failed to change username " + err.Error())
+	}
+
+	log.Printf("User %s changed username from %s to %s", user.Email, user.Username, username)
+	return u.GetOwnProfile(userID)
+}
+
+// checkUsernameAvailable returns ErrUsernameTaken if another user has the handle
+// or gave it up so recently that it still redirects to them.
+func (u *UserService) checkUsernameAvailable(username string, userID uuid.UUID) error {
+
+	existing, err := u.UserRepository.GetUserByUsername(username)
+	if err == nil && existing.ID != userID {
+		return ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error while checking username %s: %v", username, err)
+		return errors.New("error while checking username " + err.Error())
+	}
+
+	previous, err := u.UserRepository.GetUserByPreviousUsername(username, time.Now().Add(-usernameRedirectPeriod))
+	if err == nil && previous.ID != userID {
+		return ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error while checking username %s: %v", username, err)
+		return errors.New("error while checking username " + err.Error())
+	}
+
+	return nil
+}
+
+// validateUsername checks the format of a handle and that it is not reserved.
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	if reservedUsernames[strings.ToLower(username)] {
+		return ErrReservedUsername
+	}
+	return nil
+}
+
+// resolveUsername finds the user by their current handle, ignoring case.
+// If the handle belonged to a user recently, it returns a UsernameMovedError with their current handle.
+func resolveUsername(userRepository *repository.UserRepository, username string) (*models.User, error) {
+
+	user, err := userRepository.GetUserByUsername(username)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, userLookupError(username, err)
+	}
+
+	previous, err := userRepository.GetUserByPreviousUsername(username, time.Now().Add(-usernameRedirectPeriod))
+	if err != nil {
+		return nil, userLookupError(username, err)
+	}
+	return nil, &UsernameMovedError{Username: previous.Username}
+}
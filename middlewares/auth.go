@@ -1,44 +1,139 @@
 package middlewares
 
 import (
+	"blog/internal/models"
 	"blog/internal/repository"
+	"blog/utils"
 	"context"
+	"log"
 	"net/http"
+	"strings"
+	"time"
+)
 
-	"github.com/google/uuid"
+const (
+	userIDKey     string = "userID"
+	sessionIDKey  string = "sessionID"
+	authMethodKey string = "authMethod"
+	scopesKey     string = "scopes"
 )
 
-const userIDKey string = "userID"
+// Values of authMethodKey in the request context.
+const (
+	AuthMethodSession = "session"
+	AuthMethodToken   = "token"
+)
 
 // SessionMiddleware is middleware for processing user sessions.
-// It retrieves the session ID from the "sessionID" cookie, gets the userID from the repository,
-// checks it for validity and adds userID to the request context.
-// If the session is invalid or there is a data error, returns a 401 Unauthorized error.
+// It accepts either a personal access token in the "Authorization: Bearer <token>" header
+// or the session ID from the "sessionID" cookie.
+// For cookies, it loads the session from the repository (refreshing its last-seen time in the same round trip)
+// and adds userID and sessionID to the request context. Revoked sessions are deleted from Redis, so they are rejected immediately.
+// For tokens, it checks that the token exists and has not expired, and adds userID and the token scopes to the request context.
+// If the credentials are invalid, returns a 401 Unauthorized error.
 // If the check is successful, passes the request to the next handler with the updated context.
-func SessionMiddleware(userRepository *repository.UserRepository) func(http.Handler) http.Handler {
+func SessionMiddleware(userRepository *repository.UserRepository, accessTokenRepository *repository.AccessTokenRepository) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-			session, err := r.Cookie("sessionID")
-			if err != nil {
+			ctx, ok := authenticate(r, userRepository, accessTokenRepository)
+			if !ok {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			userIDStr, err := userRepository.GetUserIdBySession(session.Value)
-			if err != nil {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// OptionalSession is middleware for public routes that show more to a logged in user, such as their own drafts.
+// It accepts the same credentials as SessionMiddleware and adds the same values to the request context,
+// but lets requests without valid credentials through anonymously instead of rejecting them.
+func OptionalSession(userRepository *repository.UserRepository, accessTokenRepository *repository.AccessTokenRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if ctx, ok := authenticate(r, userRepository, accessTokenRepository); ok {
+				r = r.WithContext(ctx)
 			}
 
-			userID, err := uuid.Parse(userIDStr)
-			if err != nil {
-				http.Error(w, "Invalid session data", http.StatusUnauthorized)
-				return
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate checks the access token or session cookie of the request.
+// It returns the request context with the values of the authenticated user, or false if the credentials are missing or invalid.
+func authenticate(r *http.Request, userRepository *repository.UserRepository, accessTokenRepository *repository.AccessTokenRepository) (context.Context, bool) {
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		bearer, found := strings.CutPrefix(authorization, "Bearer ")
+		if !found {
+			return nil, false
+		}
+
+		token, err := accessTokenRepository.GetTokenByHash(utils.HashToken(strings.TrimSpace(bearer)))
+		if err != nil || time.Now().After(token.ExpiresAt) {
+			return nil, false
+		}
+
+		if err := accessTokenRepository.TouchToken(token.ID); err != nil {
+			log.Printf("Failed to update last use of token %d: %v", token.ID, err)
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, token.UserID)
+		ctx = context.WithValue(ctx, authMethodKey, AuthMethodToken)
+		ctx = context.WithValue(ctx, scopesKey, token.Scopes)
+		return ctx, true
+	}
+
+	session, err := r.Cookie("sessionID")
+	if err != nil {
+		return nil, false
+	}
+
+	userSession, err := userRepository.TouchSession(session.Value)
+	if err != nil {
+		return nil, false
+	}
+
+	ctx := context.WithValue(r.Context(), userIDKey, userSession.UserID)
+	ctx = context.WithValue(ctx, sessionIDKey, session.Value)
+	ctx = context.WithValue(ctx, authMethodKey, AuthMethodSession)
+	return ctx, true
+}
+
+// RequireScope is middleware that restricts personal access tokens to the routes their scope allows.
+// Requests authenticated with a session cookie are not limited by scopes.
+// It must be used after SessionMiddleware. If the token lacks the scope, returns a 403 Forbidden error.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Context().Value(authMethodKey) == AuthMethodToken {
+				scopes, _ := r.Context().Value(scopesKey).(models.Scopes)
+				if !scopes.Has(scope) {
+					http.Error(w, "Token is missing scope "+scope, http.StatusForbidden)
+					return
+				}
 			}
 
-			ctx := context.WithValue(r.Context(), userIDKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// RequireSessionCookie is middleware for account management routes that only a logged in user may call.
+// It must be used after SessionMiddleware. Requests made with a personal access token get a 403 Forbidden error.
+func RequireSessionCookie(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Context().Value(authMethodKey) != AuthMethodSession {
+			http.Error(w, "Personal access tokens are not allowed here", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
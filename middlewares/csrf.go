@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"blog/utils"
+	"net/http"
+)
+
+// CSRFProtect is middleware that protects cookie-authenticated routes against cross-site request forgery.
+// Unsafe requests (anything but GET, HEAD and OPTIONS) made with the session cookie must send the CSRF token
+// of their session in the X-CSRF-Token header. Requests with a personal access token are not checked,
+// since browsers never attach the Authorization header on their own.
+// It must be used after SessionMiddleware. If the token is missing or wrong, returns a 403 Forbidden error.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Context().Value(authMethodKey) == AuthMethodSession {
+			sessionID, _ := r.Context().Value(sessionIDKey).(string)
+			if !utils.ValidCSRFToken(sessionID, r.Header.Get(utils.CSRFHeader)) {
+				http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"blog/internal/repository"
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const roleKey string = "role"
+
+// RequireRole is middleware that only lets users with one of the given roles through.
+// It must be used after SessionMiddleware, which puts the userID into the request context.
+// The role is read from the database on every request, so role changes take effect immediately,
+// and it is added to the request context.
+// If the user has none of the roles, returns a 403 Forbidden error.
+func RequireRole(userRepository *repository.UserRepository, roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			userID, ok := r.Context().Value(userIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := userRepository.GetUserByID(userID)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if user.Role == role {
+					ctx := context.WithValue(r.Context(), roleKey, user.Role)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"blog/internal/repository"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequireVerifiedEmail is middleware that only lets users with a verified email through.
+// It must be used after SessionMiddleware, which puts the userID into the request context.
+// If the user's email is not verified, returns a 403 Forbidden error.
+func RequireVerifiedEmail(userRepository *repository.UserRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			userID, ok := r.Context().Value(userIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := userRepository.GetUserByID(userID)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !user.IsVerified {
+				http.Error(w, "Email not verified", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
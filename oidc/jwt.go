@@ -0,0 +1,263 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clockSkew is the tolerance for the time claims of ID tokens.
+const clockSkew = time.Minute
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// idTokenClaims are the registered claims checked during verification.
+type idTokenClaims struct {
+	Claims
+	Audience  audience `json:"aud"`
+	AZP       string   `json:"azp"`
+	Nonce     string   `json:"nonce"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+}
+
+// audience is the "aud" claim, which can be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyIDToken checks the signature of an ID token against the provider's keys and validates its claims
+// as described in OpenID Connect Core 1.0, section 3.1.3.7.
+func (p *Provider) verifyIDToken(ctx context.Context, token, nonce string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidIDToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidIDToken)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: token is not for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AZP != p.config.ClientID:
+		return nil, fmt.Errorf("%w: token was issued to another party", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)):
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims.Claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks an RS256 or ES256 signature. Other algorithms, including "none", are rejected.
+func verifySignature(alg string, key interface{}, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match RS256")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("key type does not match ES256")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// signingKey returns the provider key with the given ID.
+// The key set is fetched again once when the ID is unknown, so key rotation at the provider is picked up.
+func (p *Provider) signingKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// A provider with a single key may leave out the key ID.
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return fmt.Errorf("fetching signing keys failed: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,391 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "blog-client"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:8080/login/oidc/callback"
+	testCode         = "auth-code"
+	testVerifier     = "pkce-verifier"
+	testNonce        = "nonce-123"
+)
+
+// mockProvider is a minimal OpenID Connect provider serving discovery, a key set and the token endpoint.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu             sync.Mutex
+	idToken        string
+	issuer         string
+	keys           []map[string]string
+	jwksRequests   int
+	discoveryCalls int
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{t: t, rsaKey: rsaKey, ecKey: ecKey}
+	m.keys = []map[string]string{m.rsaJWK("rsa-1"), m.ecJWK("ec-1")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	m.issuer = m.server.URL
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Issuer:       m.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.discoveryCalls++
+	issuer := m.issuer
+	m.mu.Unlock()
+
+	writeJSON(w, map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": m.server.URL + "/authorize",
+		"token_endpoint":         m.server.URL + "/token",
+		"jwks_uri":               m.server.URL + "/jwks",
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jwksRequests++
+	writeJSON(w, map[string]interface{}{"keys": m.keys})
+}
+
+// token checks the authorization code request of Exchange and answers with the prepared ID token.
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	switch {
+	case r.Method != http.MethodPost:
+		http.Error(w, "method", http.StatusMethodNotAllowed)
+	case !ok || clientID != testClientID || secret != testClientSecret:
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+	case r.PostForm.Get("grant_type") != "authorization_code", r.PostForm.Get("code") != testCode,
+		r.PostForm.Get("redirect_uri") != testRedirectURL, r.PostForm.Get("code_verifier") != testVerifier:
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	default:
+		m.mu.Lock()
+		idToken := m.idToken
+		m.mu.Unlock()
+		writeJSON(w, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	}
+}
+
+func (m *mockProvider) setIDToken(token string) {
+	m.mu.Lock()
+	m.idToken = token
+	m.mu.Unlock()
+}
+
+func (m *mockProvider) rsaJWK(kid string) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+		"n": b64(m.rsaKey.N.Bytes()),
+		"e": b64(big.NewInt(int64(m.rsaKey.E)).Bytes()),
+	}
+}
+
+func (m *mockProvider) ecJWK(kid string) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "use": "sig", "alg": "ES256", "crv": "P-256",
+		"x": b64(m.ecKey.X.FillBytes(make([]byte, 32))),
+		"y": b64(m.ecKey.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// claims returns valid ID token claims for the mock provider.
+func (m *mockProvider) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+// sign builds a JWT with the given header and claims, signed with the key matching the algorithm.
+func (m *mockProvider) sign(header, claims map[string]interface{}) string {
+	m.t.Helper()
+
+	signed := encodeSegment(m.t, header) + "." + encodeSegment(m.t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch header["alg"] {
+	case "RS256":
+		sig, err := rsa.SignPKCS1v15(rand.Reader, m.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		signature = sig
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, m.ecKey, digest[:])
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state-1", testNonce, testVerifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsed.Scheme+"://"+parsed.Host+parsed.Path, m.server.URL+"/authorize"; got != want {
+		t.Errorf("endpoint = %q, want %q", got, want)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge":        CodeChallenge(testVerifier),
+		"code_challenge_method": "S256",
+	}
+	query := parsed.Query()
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// The discovery document is cached.
+	if _, err := p.AuthCodeURL(context.Background(), "state-2", testNonce, testVerifier); err != nil {
+		t.Fatal(err)
+	}
+	if m.discoveryCalls != 1 {
+		t.Errorf("discovery fetched %d times, want 1", m.discoveryCalls)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636, Appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.issuer = "https://evil.example.com"
+
+	if _, err := m.provider().AuthCodeURL(context.Background(), "state", testNonce, testVerifier); err == nil {
+		t.Fatal("AuthCodeURL() accepted a discovery document of another issuer")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "rsa-1", "typ": "JWT"}
+	es256 := map[string]interface{}{"alg": "ES256", "kid": "ec-1", "typ": "JWT"}
+
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := m.claims()
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	unsigned := func(claims map[string]interface{}) string {
+		return encodeSegment(t, map[string]interface{}{"alg": "none", "kid": "rsa-1"}) + "." + encodeSegment(t, claims) + "."
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		nonce   string
+		wantErr bool
+	}{
+		{name: "RS256", token: m.sign(rs256, m.claims())},
+		{name: "ES256", token: m.sign(es256, m.claims())},
+		{name: "audience list with azp", token: m.sign(rs256, with(map[string]interface{}{
+			"aud": []string{testClientID, "other"}, "azp": testClientID,
+		}))},
+		{name: "expired within clock skew", token: m.sign(rs256, with(map[string]interface{}{
+			"exp": time.Now().Add(-30 * time.Second).Unix(),
+		}))},
+		{name: "alg none", token: unsigned(m.claims()), wantErr: true},
+		{name: "alg HS256", token: m.sign(map[string]interface{}{"alg": "HS256", "kid": "rsa-1"}, m.claims()), wantErr: true},
+		{name: "ES256 header with RSA key", token: m.sign(map[string]interface{}{"alg": "ES256", "kid": "rsa-1"}, m.claims()), wantErr: true},
+		{name: "tampered claims", token: tamper(t, m.sign(rs256, m.claims())), wantErr: true},
+		{name: "unknown key", token: m.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa-9"}, m.claims()), wantErr: true},
+		{name: "wrong nonce", token: m.sign(rs256, m.claims()), nonce: "other-nonce", wantErr: true},
+		{name: "missing nonce", token: m.sign(rs256, with(map[string]interface{}{"nonce": nil})), wantErr: true},
+		{name: "wrong audience", token: m.sign(rs256, with(map[string]interface{}{"aud": "other"})), wantErr: true},
+		{name: "audience list without azp", token: m.sign(rs256, with(map[string]interface{}{
+			"aud": []string{testClientID, "other"},
+		})), wantErr: true},
+		{name: "audience list with foreign azp", token: m.sign(rs256, with(map[string]interface{}{
+			"aud": []string{testClientID, "other"}, "azp": "other",
+		})), wantErr: true},
+		{name: "wrong issuer", token: m.sign(rs256, with(map[string]interface{}{"iss": "https://evil.example.com"})), wantErr: true},
+		{name: "expired", token: m.sign(rs256, with(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), wantErr: true},
+		{name: "issued in the future", token: m.sign(rs256, with(map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()})), wantErr: true},
+		{name: "missing subject", token: m.sign(rs256, with(map[string]interface{}{"sub": nil})), wantErr: true},
+		{name: "malformed", token: "not-a-jwt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.setIDToken(tt.token)
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = testNonce
+			}
+
+			claims, err := m.provider().Exchange(context.Background(), testCode, testVerifier, nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Exchange() accepted an invalid ID token")
+				}
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Errorf("Exchange() error = %v, want ErrInvalidIDToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Issuer != m.server.URL {
+				t.Errorf("Exchange() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectedGrant(t *testing.T) {
+	m := newMockProvider(t)
+	m.setIDToken(m.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, m.claims()))
+
+	if _, err := m.provider().Exchange(context.Background(), testCode, "wrong-verifier", testNonce); err == nil {
+		t.Fatal("Exchange() succeeded with a wrong PKCE verifier")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	m.setIDToken(m.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, m.claims()))
+	if _, err := p.Exchange(context.Background(), testCode, testVerifier, testNonce); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	// The provider starts signing with a new key ID; the key set is fetched again once.
+	m.mu.Lock()
+	m.keys = []map[string]string{m.rsaJWK("rsa-2")}
+	m.mu.Unlock()
+
+	m.setIDToken(m.sign(map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, m.claims()))
+	if _, err := p.Exchange(context.Background(), testCode, testVerifier, testNonce); err != nil {
+		t.Fatalf("Exchange() after key rotation error = %v", err)
+	}
+	if m.jwksRequests != 2 {
+		t.Errorf("key set fetched %d times, want 2", m.jwksRequests)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64(data)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// tamper replaces the claims of a signed token, keeping the original signature.
+func tamper(t *testing.T, token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = encodeSegment(t, map[string]interface{}{"sub": "admin", "iss": "x", "aud": testClientID})
+	return strings.Join(parts, ".")
+}
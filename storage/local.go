@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps files in a directory on the local filesystem.
+// The files are expected to be served under BaseURL, e.g. with http.FileServer(l.FileSystem()).
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+func (l *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first, so readers never see a partially written file.
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return l.BaseURL + "/" + key, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// FileSystem returns the storage directory as a file system for http.FileServer.
+// Directories are reported as missing, so the server answers 404 instead of listing the stored files.
+func (l *LocalStorage) FileSystem() http.FileSystem {
+	return filesOnly{http.Dir(l.Dir)}
+}
+
+// filesOnly is a file system that hides the directories of the wrapped one.
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+// path maps the key to a file inside the storage directory, rejecting keys that would escape it.
+func (l *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(cleaned)), nil
+}
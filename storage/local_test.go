@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoragePutDelete(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+	local, err := NewLocalStorage(dir, "/media/")
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error = %v", err)
+	}
+	ctx := context.Background()
+
+	url, err := local.Put(ctx, "avatars/user/large.png", []byte("png data"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if want := "/media/avatars/user/large.png"; url != want {
+		t.Errorf("Put() url = %q, want %q", url, want)
+	}
+
+	filePath := filepath.Join(dir, "avatars", "user", "large.png")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("stored file: %v", err)
+	}
+	if string(data) != "png data" {
+		t.Errorf("stored file = %q, want %q", data, "png data")
+	}
+	if _, err := os.Stat(filePath + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// Overwriting replaces the file.
+	if _, err := local.Put(ctx, "avatars/user/large.png", []byte("new data"), "image/png"); err != nil {
+		t.Fatalf("Put() overwrite error = %v", err)
+	}
+	if data, _ := os.ReadFile(filePath); string(data) != "new data" {
+		t.Errorf("overwritten file = %q, want %q", data, "new data")
+	}
+
+	if err := local.Delete(ctx, "avatars/user/large.png"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file still exists after Delete(): %v", err)
+	}
+	if err := local.Delete(ctx, "avatars/user/large.png"); err != nil {
+		t.Errorf("Delete() of a missing file error = %v", err)
+	}
+}
+
+func TestLocalStorageInvalidKeys(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), "/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"", "/", "../outside.png", "avatars/../../outside.png", "/absolute.png", "avatars//double.png", "avatars/./dot.png"}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			if _, err := local.Put(context.Background(), key, []byte("x"), "image/png"); err == nil {
+				t.Errorf("Put(%q) succeeded, want an error", key)
+			}
+			if err := local.Delete(context.Background(), key); err == nil {
+				t.Errorf("Delete(%q) succeeded, want an error", key)
+			}
+		})
+	}
+}
+
+func TestLocalStorageFileSystem(t *testing.T) {
+	local, err := NewLocalStorage(t.TempDir(), "/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Put(context.Background(), "avatars/user/thumb.png", []byte("thumb"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.StripPrefix("/media/", http.FileServer(local.FileSystem())))
+	defer server.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/media/avatars/user/thumb.png", wantStatus: http.StatusOK, wantBody: "thumb"},
+		{path: "/media/", wantStatus: http.StatusNotFound},
+		{path: "/media/avatars/", wantStatus: http.StatusNotFound},
+		{path: "/media/avatars/user", wantStatus: http.StatusNotFound},
+		{path: "/media/avatars/user/missing.png", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
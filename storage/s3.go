@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config describes an S3-compatible bucket, e.g. on AWS or a local MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the S3 API, e.g. "http://localhost:9000" for MinIO.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is the base URL the stored files are served from.
+	// If empty, the path-style URL of the object on Endpoint is used.
+	PublicURL string
+}
+
+// S3Storage keeps files in an S3-compatible bucket, using path-style requests signed with AWS Signature Version 4.
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	signer   signer
+	// now returns the time requests are signed at.
+	now func() time.Time
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 endpoint, bucket and credentials are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+
+	return &S3Storage{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		signer:   signer{accessKey: config.AccessKey, secretKey: config.SecretKey, region: config.Region, service: "s3"},
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	req, err := s.newRequest(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if err := s.do(req); err != nil {
+		return "", err
+	}
+
+	if s.config.PublicURL != "" {
+		return strings.TrimRight(s.config.PublicURL, "/") + "/" + key, nil
+	}
+	return s.objectURL(key), nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req)
+}
+
+// do sends the request and turns non-2xx responses into errors. S3 answers deletes of missing objects with 204.
+func (s *S3Storage) do(req *http.Request) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	return s.endpoint.String() + s.objectPath(key)
+}
+
+func (s *S3Storage) objectPath(key string) string {
+	segments := strings.Split(s.config.Bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return s.endpoint.EscapedPath() + "/" + strings.Join(segments, "/")
+}
+
+// newRequest builds a request for the object and signs it with AWS Signature Version 4.
+func (s *S3Storage) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	s.signer.sign(req, s.objectPath(key), payloadHash, []string{"x-amz-content-sha256"}, s.now())
+	return req, nil
+}
+
+// signer signs requests with AWS Signature Version 4 for one service in one region.
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// sign sets the X-Amz-Date and Authorization headers of the request. The host, the date and the given headers
+// (in lower case) are signed; canonicalPath is the URI-encoded path and payloadHash the hex SHA-256 of the body.
+// Requests are expected to have no query string.
+func (s signer) sign(req *http.Request, canonicalPath, payloadHash string, headers []string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := append([]string{"host", "x-amz-date"}, headers...)
+	sort.Strings(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		"",
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// signingKey derives the key for the date, region and service from the secret key.
+func (s signer) signingKey(date string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes a path segment the way Signature Version 4 expects: everything except unreserved characters.
+func uriEncode(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Credentials and time of the AWS Signature Version 4 test suite.
+const (
+	suiteAccessKey = "AKIDEXAMPLE"
+	suiteSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	emptyHash      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var suiteTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+func TestSignerTestSuite(t *testing.T) {
+	suite := signer{accessKey: suiteAccessKey, secretKey: suiteSecretKey, region: "us-east-1", service: "service"}
+
+	tests := []struct {
+		name     string
+		method   string
+		wantAuth string
+	}{
+		{
+			name:   "get-vanilla",
+			method: http.MethodGet,
+			wantAuth: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+				"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "post-vanilla",
+			method: http.MethodPost,
+			wantAuth: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, " +
+				"Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://example.amazonaws.com/", nil)
+			suite.sign(req, "/", emptyHash, nil, suiteTime)
+
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+			}
+			if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("Authorization = %q\nwant %q", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	// Example of deriving a signing key from the AWS documentation.
+	s := signer{secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", region: "us-east-1", service: "iam"}
+
+	got := hex.EncodeToString(s.signingKey("20120215"))
+	if want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"; got != want {
+		t.Errorf("signingKey() = %s, want %s", got, want)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	tests := map[string]string{
+		"avatar.png":    "avatar.png",
+		"a b+c":         "a%20b%2Bc",
+		"test$file.txt": "test%24file.txt",
+		"ü~_-.":         "%C3%BC~_-.",
+	}
+	for segment, want := range tests {
+		if got := uriEncode(segment); got != want {
+			t.Errorf("uriEncode(%q) = %q, want %q", segment, got, want)
+		}
+	}
+}
+
+// s3Request is what the fake S3 server saw of a request.
+type s3Request struct {
+	method  string
+	path    string
+	header  http.Header
+	body    string
+	rawPath string
+}
+
+func newFakeS3(t *testing.T, status int) (*httptest.Server, *[]s3Request) {
+	t.Helper()
+
+	var requests []s3Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, s3Request{
+			method: r.Method, path: r.URL.Path, rawPath: r.URL.EscapedPath(), header: r.Header.Clone(), body: string(body),
+		})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestS3Storage(t *testing.T, endpoint, publicURL string) *S3Storage {
+	t.Helper()
+
+	s3, err := NewS3Storage(S3Config{
+		Endpoint:  endpoint + "/",
+		Bucket:    "blog",
+		AccessKey: suiteAccessKey,
+		SecretKey: suiteSecretKey,
+		PublicURL: publicURL,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage() error = %v", err)
+	}
+	s3.now = func() time.Time { return suiteTime }
+	return s3
+}
+
+func TestS3StoragePut(t *testing.T) {
+	server, requests := newFakeS3(t, http.StatusOK)
+	s3 := newTestS3Storage(t, server.URL, "")
+
+	url, err := s3.Put(context.Background(), "avatars/a b/large.png", []byte("png data"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if want := server.URL + "/blog/avatars/a%20b/large.png"; url != want {
+		t.Errorf("Put() url = %q, want %q", url, want)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+
+	if req.method != http.MethodPut || req.rawPath != "/blog/avatars/a%20b/large.png" {
+		t.Errorf("request = %s %s, want PUT /blog/avatars/a%%20b/large.png", req.method, req.rawPath)
+	}
+	if req.body != "png data" {
+		t.Errorf("body = %q, want %q", req.body, "png data")
+	}
+	if got := req.header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if got, want := req.header.Get("X-Amz-Content-Sha256"), sha256Hex([]byte("png data")); got != want {
+		t.Errorf("X-Amz-Content-Sha256 = %q, want %q", got, want)
+	}
+	if got := req.header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+	}
+
+	// The server checks the signature by signing the request it received again.
+	auth := req.header.Get("Authorization")
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(auth, wantPrefix) {
+		t.Fatalf("Authorization = %q, want prefix %q", auth, wantPrefix)
+	}
+
+	received := httptest.NewRequest(req.method, server.URL+req.rawPath, nil)
+	received.Header.Set("X-Amz-Content-Sha256", sha256Hex([]byte(req.body)))
+	verifier := signer{accessKey: suiteAccessKey, secretKey: suiteSecretKey, region: "us-east-1", service: "s3"}
+	verifier.sign(received, req.rawPath, sha256Hex([]byte(req.body)), []string{"x-amz-content-sha256"}, suiteTime)
+	if got := received.Header.Get("Authorization"); got != auth {
+		t.Errorf("signature does not match the received request:\n got %q\nwant %q", auth, got)
+	}
+}
+
+func TestS3StoragePublicURL(t *testing.T) {
+	server, _ := newFakeS3(t, http.StatusOK)
+	s3 := newTestS3Storage(t, server.URL, "https://cdn.example.com/")
+
+	url, err := s3.Put(context.Background(), "avatars/user/thumb.png", []byte("x"), "image/png")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if want := "https://cdn.example.com/avatars/user/thumb.png"; url != want {
+		t.Errorf("Put() url = %q, want %q", url, want)
+	}
+}
+
+func TestS3StorageDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "deleted", status: http.StatusNoContent},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newFakeS3(t, tt.status)
+			s3 := newTestS3Storage(t, server.URL, "")
+
+			err := s3.Delete(context.Background(), "avatars/user/thumb.png")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Delete() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			req := (*requests)[0]
+			if req.method != http.MethodDelete || req.path != "/blog/avatars/user/thumb.png" {
+				t.Errorf("request = %s %s, want DELETE /blog/avatars/user/thumb.png", req.method, req.path)
+			}
+			if got := req.header.Get("X-Amz-Content-Sha256"); got != emptyHash {
+				t.Errorf("X-Amz-Content-Sha256 = %q, want the hash of an empty body", got)
+			}
+		})
+	}
+}
+
+func TestNewS3StorageRequiresConfig(t *testing.T) {
+	if _, err := NewS3Storage(S3Config{Endpoint: "http://localhost:9000", Bucket: "blog"}); err == nil {
+		t.Error("NewS3Storage() without credentials succeeded")
+	}
+}
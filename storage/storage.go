@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Storage keeps uploaded files such as avatars and returns public URLs for them.
+type Storage interface {
+	// Put stores the data under the key and returns the public URL of the file.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Delete removes the file stored under the key. Deleting a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Creating the storage configured by the STORAGE_DRIVER environment variable ("local" by default or "s3")
+func NewFromEnv() (Storage, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		baseURL := os.Getenv("STORAGE_LOCAL_BASE_URL")
+		if baseURL == "" {
+			baseURL = "/media"
+		}
+		return NewLocalStorage(dir, baseURL)
+	case "s3":
+		return NewS3Storage(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", driver)
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Returns the IP address of the client that sent the request.
+// X-Forwarded-For is only trusted when TRUST_PROXY_HEADERS is "true",
+// otherwise the header could be spoofed by the client.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
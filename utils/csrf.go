@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"os"
+	"sync"
+)
+
+// CSRFHeader is the request header that must carry the CSRF token on unsafe requests made with the session cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+var (
+	csrfSecretOnce sync.Once
+	csrfSecret     []byte
+)
+
+// Returns the CSRF token of a session. The token is an HMAC of the session ID,
+// so it is bound to the session, needs no storage and cannot be derived without the secret.
+func CSRFToken(sessionID string) string {
+	mac := hmac.New(sha256.New, csrfKey())
+	mac.Write([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Checks the CSRF token sent with a request against the session.
+func ValidCSRFToken(sessionID, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(CSRFToken(sessionID)))
+}
+
+// csrfKey returns CSRF_SECRET. Without it, a random key is generated on first use,
+// which invalidates all CSRF tokens when the application restarts.
+func csrfKey() []byte {
+	csrfSecretOnce.Do(func() {
+		if secret := os.Getenv("CSRF_SECRET"); secret != "" {
+			csrfSecret = []byte(secret)
+			return
+		}
+		log.Printf("CSRF_SECRET is not set, using a random key until restart")
+		csrfSecret = make([]byte, 32)
+		if _, err := rand.Read(csrfSecret); err != nil {
+			log.Fatalf("Failed to generate CSRF key: %v", err)
+		}
+	})
+	return csrfSecret
+}
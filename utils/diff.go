@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines shown around each change.
+	diffContext = 3
+
+	// MaxDiffInputSize is the largest text in bytes that UnifiedDiff compares.
+	MaxDiffInputSize = 1 << 20
+	// maxDiffCells bounds the table of the longest common subsequence, which needs
+	// one cell for every pair of lines that differ between the texts (4M cells take 16 MB).
+	maxDiffCells = 1 << 22
+)
+
+// ErrDiffTooLarge is returned by UnifiedDiff if the texts are too large or differ in too many lines to compare.
+var ErrDiffTooLarge = errors.New("texts are too large to compare")
+
+// diffLine is a line of a diff together with its kind: ' ' unchanged, '-' removed or '+' added.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// UnifiedDiff returns the line-based differences between two texts in the unified diff format,
+// with fromName and toName as the file names in the header. It returns an empty string if the texts are equal.
+// It returns ErrDiffTooLarge if a text is larger than MaxDiffInputSize or the changed parts are too long to compare.
+func UnifiedDiff(fromName, toName, from, to string) (string, error) {
+	if len(from) > MaxDiffInputSize || len(to) > MaxDiffInputSize {
+		return "", ErrDiffTooLarge
+	}
+
+	lines, err := diffLines(splitLines(from), splitLines(to))
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for start := 0; start < len(lines); {
+		// Find the next change and the end of its hunk, merging changes whose context overlaps.
+		first := start
+		for first < len(lines) && lines[first].kind == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first + 1; i < len(lines) && i-last <= 2*diffContext; i++ {
+			if lines[i].kind != ' ' {
+				last = i
+			}
+		}
+
+		hunkStart := max(first-diffContext, start)
+		hunkEnd := min(last+diffContext+1, len(lines))
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&b, lines, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return b.String(), nil
+}
+
+// writeHunk writes the lines between start and end as one hunk with its header.
+func writeHunk(b *strings.Builder, lines []diffLine, start, end int) {
+	fromLine, toLine := 1, 1
+	for _, line := range lines[:start] {
+		if line.kind != '+' {
+			fromLine++
+		}
+		if line.kind != '-' {
+			toLine++
+		}
+	}
+
+	fromCount, toCount := 0, 0
+	for _, line := range lines[start:end] {
+		if line.kind != '+' {
+			fromCount++
+		}
+		if line.kind != '-' {
+			toCount++
+		}
+	}
+	// An empty range starts at the line before it.
+	if fromCount == 0 {
+		fromLine--
+	}
+	if toCount == 0 {
+		toLine--
+	}
+
+	fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+	for _, line := range lines[start:end] {
+		b.WriteByte(line.kind)
+		b.WriteString(line.text)
+		b.WriteByte('\n')
+	}
+}
+
+// diffLines computes the shortest edit from a to b using their longest common subsequence.
+// The common prefix and suffix are skipped first, so small edits of long texts stay cheap.
+// It returns ErrDiffTooLarge if the remaining lines need more than maxDiffCells table cells.
+func diffLines(a, b []string) ([]diffLine, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		return nil, ErrDiffTooLarge
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:].
+	cells := make([]int32, (len(midA)+1)*(len(midB)+1))
+	lcs := make([][]int32, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = cells[i*(len(midB)+1) : (i+1)*(len(midB)+1)]
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			lines = append(lines, diffLine{' ', midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', midA[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		lines = append(lines, diffLine{'-', midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		lines = append(lines, diffLine{'+', midB[j]})
+	}
+
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines, nil
+}
+
+// splitLines splits the text into lines, ignoring a trailing newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
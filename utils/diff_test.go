@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "identical",
+			from: "a\nb\nc\n",
+			to:   "a\nb\nc\n",
+			want: "",
+		},
+		{
+			name: "both empty",
+			want: "",
+		},
+		{
+			name: "changed line",
+			from: "a\nb\nc\n",
+			to:   "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "insert only",
+			from: "a\nb\n",
+			to:   "a\nx\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,3 @@\n a\n+x\n b\n",
+		},
+		{
+			name: "delete only",
+			from: "a\nx\nb\n",
+			to:   "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n-x\n b\n",
+		},
+		{
+			name: "from empty",
+			from: "",
+			to:   "x\ny\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name: "to empty",
+			from: "x\ny\n",
+			to:   "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "separate hunks",
+			from: numberedLines(1, 15),
+			to:   strings.Replace(strings.Replace(numberedLines(1, 15), "l2\n", "l2x\n", 1), "l14\n", "", 1),
+			want: "--- old\n+++ new\n" +
+				"@@ -1,5 +1,5 @@\n l1\n-l2\n+l2x\n l3\n l4\n l5\n" +
+				"@@ -11,5 +11,4 @@\n l11\n l12\n l13\n-l14\n l15\n",
+		},
+		{
+			name: "merged hunks",
+			from: numberedLines(1, 10),
+			to:   strings.Replace(strings.Replace(numberedLines(1, 10), "l2\n", "l2x\n", 1), "l7\n", "l7x\n", 1),
+			want: "--- old\n+++ new\n" +
+				"@@ -1,10 +1,10 @@\n l1\n-l2\n+l2x\n l3\n l4\n l5\n l6\n-l7\n+l7x\n l8\n l9\n l10\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnifiedDiff("old", "new", tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("UnifiedDiff() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+	}{
+		{
+			name: "input too large",
+			from: strings.Repeat("x", MaxDiffInputSize+1),
+			to:   "x",
+		},
+		{
+			name: "too many changed lines",
+			from: numberedLines(1, 20000),
+			to:   numberedLines(20001, 40000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := UnifiedDiff("old", "new", tt.from, tt.to); !errors.Is(err, ErrDiffTooLarge) {
+				t.Errorf("UnifiedDiff() error = %v, want ErrDiffTooLarge", err)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLongCommonText(t *testing.T) {
+	// A small edit of a long text only compares the changed lines.
+	from := numberedLines(1, 50000)
+	to := strings.Replace(from, "l25000\n", "changed\n", 1)
+
+	got, err := UnifiedDiff("old", "new", from, to)
+	if err != nil {
+		t.Fatalf("UnifiedDiff() error = %v", err)
+	}
+	if !strings.Contains(got, "@@ -24997,7 +24997,7 @@\n") || !strings.Contains(got, "-l25000\n+changed\n") {
+		t.Errorf("UnifiedDiff() =\n%s", got)
+	}
+}
+
+// numberedLines returns the lines "l<from>" to "l<to>", each ending with a newline.
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "l%d\n", i)
+	}
+	return b.String()
+}
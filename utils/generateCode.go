@@ -26,3 +26,24 @@ func GenerateSessionID() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// Generates a random URL-safe token for one-time links.
+func GenerateToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Generates a one-time recovery code in the form "xxxxx-xxxxx".
+func GenerateRecoveryCode() (string, error) {
+	bytes := make([]byte, 5)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(bytes)
+	return code[:5] + "-" + code[5:], nil
+}
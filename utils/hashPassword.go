@@ -1,20 +1,197 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Hashes the password
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+var (
+	// ErrPasswordMismatch is returned when a password does not match the stored hash.
+	ErrPasswordMismatch = errors.New("password does not match")
+	// ErrUnknownHashFormat is returned for stored hashes of an unsupported algorithm.
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// PasswordHasher hashes new passwords with one algorithm, but verifies hashes of every supported algorithm,
+// so passwords hashed with an older algorithm keep working until they are upgraded.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password, including the algorithm and its parameters.
+	Hash(password string) (string, error)
+	// Verify checks the password against an encoded hash. It returns ErrPasswordMismatch if they do not match.
+	Verify(password, hash string) error
+	// NeedsRehash reports whether the hash was made with another algorithm or other parameters than Hash uses now.
+	NeedsRehash(hash string) bool
+}
+
+// Argon2Params are the cost parameters of Argon2id.
+type Argon2Params struct {
+	Memory    uint32 // in KiB
+	Time      uint32
+	Threads   uint8
+	SaltLen   uint32
+	KeyLength uint32
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106 with 64 MiB of memory.
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLength: 32}
+
+// Argon2idHasher hashes passwords with Argon2id, encoded as $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>.
+type Argon2idHasher struct {
+	Params Argon2Params
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.Params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Params.Time, a.Params.Memory, a.Params.Threads, a.Params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Params.Memory, a.Params.Time, a.Params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2idHasher) Verify(password, hash string) error {
+	return verifyPassword(password, hash)
+}
+
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.Params.Memory || params.Time != a.Params.Time ||
+		params.Threads != a.Params.Threads || uint32(len(key)) != a.Params.KeyLength
+}
+
+// BcryptHasher hashes passwords with bcrypt. It is kept for deployments that cannot afford the memory of Argon2id.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
 	if err != nil {
 		return "", err
 	}
 	return string(hashedPassword), nil
 }
 
-// Сhecks the hashed password against the user's password
-func CheckPasswordHash(password, hash string) error {
+func (b *BcryptHasher) Verify(password, hash string) error {
+	return verifyPassword(password, hash)
+}
+
+func (b *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.Cost
+}
+
+// Creating the password hasher configured by the environment.
+// PASSWORD_HASH_ALGORITHM selects "argon2id" (default) or "bcrypt".
+// ARGON2_MEMORY_KB, ARGON2_TIME and ARGON2_THREADS override the Argon2id parameters, BCRYPT_COST the bcrypt cost.
+func NewPasswordHasherFromEnv() (PasswordHasher, error) {
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "", "argon2id":
+		params := DefaultArgon2Params
+		for _, setting := range []struct {
+			env   string
+			value *uint32
+		}{{"ARGON2_MEMORY_KB", &params.Memory}, {"ARGON2_TIME", &params.Time}} {
+			if v := os.Getenv(setting.env); v != "" {
+				n, err := strconv.ParseUint(v, 10, 32)
+				if err != nil || n == 0 {
+					return nil, fmt.Errorf("invalid %s %q", setting.env, v)
+				}
+				*setting.value = uint32(n)
+			}
+		}
+		if v := os.Getenv("ARGON2_THREADS"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("invalid ARGON2_THREADS %q", v)
+			}
+			params.Threads = uint8(n)
+		}
+		return &Argon2idHasher{Params: params}, nil
+	case "bcrypt":
+		cost := bcrypt.DefaultCost
+		if v := os.Getenv("BCRYPT_COST"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+				return nil, fmt.Errorf("invalid BCRYPT_COST %q", v)
+			}
+			cost = n
+		}
+		return &BcryptHasher{Cost: cost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+}
+
+// verifyPassword checks the password against a hash of any supported algorithm.
+func verifyPassword(password, hash string) error {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return ErrUnknownHashFormat
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
 	return err
 }
+
+// decodeArgon2id parses the parameters, salt and key of an encoded Argon2id hash.
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
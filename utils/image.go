@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Crops the image to a centered square and scales it to size x size pixels.
+func SquareThumbnail(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
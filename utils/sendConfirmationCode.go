@@ -10,6 +10,11 @@ import (
 
 // Sending code by email
 func SendEmail(email string, code string) error {
+	return SendMail(email, "Email Verification Code", fmt.Sprintf("Your email confirmation code: %s", code))
+}
+
+// Sending an arbitrary plain text message by email
+func SendMail(email, subject, body string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := 465
 	senderEmail := os.Getenv("EMAIL_USER")
@@ -18,8 +23,8 @@ func SendEmail(email string, code string) error {
 	message := gomail.NewMessage()
 	message.SetHeader("From", senderEmail)
 	message.SetHeader("To", email)
-	message.SetHeader("Subject", "Email Verification Code")
-	message.SetBody("text/plain", fmt.Sprintf("Your email confirmation code: %s", code))
+	message.SetHeader("Subject", subject)
+	message.SetBody("text/plain", body)
 
 	dialer := gomail.NewDialer(smtpHost, smtpPort, senderEmail, senderPassword)
 	dialer.SSL = true
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Hashes a random token (session ID, reset token, etc.) for storage or public display.
+// Tokens are high-entropy, so a plain SHA-256 is enough here.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Appends an HMAC-SHA256 signature to a token, so forged tokens can be rejected without a lookup.
+func SignToken(token, secret string) string {
+	return token + "." + tokenSignature(token, secret)
+}
+
+// Checks the signature of a token created by SignToken and returns the token without it.
+func VerifySignedToken(signed, secret string) (string, bool) {
+	token, signature, found := strings.Cut(signed, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(tokenSignature(token, secret))) {
+		return "", false
+	}
+	return token, true
+}
+
+func tokenSignature(token, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
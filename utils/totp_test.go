@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B lists 8-digit codes; 6-digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) error = %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeLowerCaseSecret(t *testing.T) {
+	got, err := TOTPCode(strings.ToLower(rfcSecret), time.Unix(59, 0))
+	if err != nil || got != "287082" {
+		t.Errorf("TOTPCode() with a lower case secret = %s, %v, want 287082", got, err)
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	codeAt := func(offset time.Duration) string {
+		code, err := TOTPCode(rfcSecret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current period", code: codeAt(0), wantStep: step, wantOK: true},
+		{name: "previous period", code: codeAt(-totpPeriod * time.Second), wantStep: step - 1, wantOK: true},
+		{name: "next period", code: codeAt(totpPeriod * time.Second), wantStep: step + 1, wantOK: true},
+		{name: "two periods ago", code: codeAt(-2 * totpPeriod * time.Second)},
+		{name: "two periods ahead", code: codeAt(2 * totpPeriod * time.Second)},
+		{name: "wrong code", code: "000000"},
+		{name: "empty code", code: ""},
+		{name: "code with spaces", code: " " + codeAt(0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(rfcSecret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTP(%q) ok = %v, want %v", tt.code, ok, tt.wantOK)
+			}
+			if ok && gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP(%q) step = %d, want %d", tt.code, gotStep, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInvalidSecret(t *testing.T) {
+	if _, ok := ValidateTOTP("not base32!", "123456", time.Unix(59, 0)); ok {
+		t.Error("ValidateTOTP() accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("blog", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/blog:alice@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/blog:alice@example.com", uri)
+	}
+	want := map[string]string{"secret": rfcSecret, "issuer": "blog", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for name, value := range want {
+		if got := uri.Query().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}